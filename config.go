@@ -1,7 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 const DEFAULT_MATCH_RULE = "all"
@@ -13,25 +21,136 @@ type OutputMap map[string]any
 
 // Config represents the configuration as defined in YAML.
 type Config struct {
-	MatchRule       string               `yaml:"match-rule"`
-	CloneOriginal   bool                 `yaml:"clone-original"`
-	CommonOutput    []OutputMap          `yaml:"common-output"`
-	SpecificOutputs []SpecificOutputRule `yaml:"specific-outputs"`
-	InputFormat     string
-	OutputFormat    string
-	Buffered        bool
+	MatchRule         string               `yaml:"match-rule"`
+	CloneOriginal     bool                 `yaml:"clone-original"`
+	CommonOutput      []OutputMap          `yaml:"common-output"`
+	SpecificOutputs   []SpecificOutputRule `yaml:"specific-outputs"`
+	FixedCols         string               `yaml:"fixed-cols"`
+	KeepKeys          []string             `yaml:"keep-keys"`
+	DropKeys          []string             `yaml:"drop-keys"`
+	CSVColumns        []string             `yaml:"csv-columns"`
+	CSVInferTypes     bool                 `yaml:"csv-infer-types"`
+	InputFile         string
+	InputFormat       string
+	OutputFormat      string
+	Outputs           []OutputTarget
+	Buffered          bool
+	Repair            bool
+	Dedupe            bool
+	Explain           bool
+	StripPrefixTo     string
+	PrefixField       string
+	JSONIndent        string
+	MaxBuffer         int
+	Reservoir         int
+	Seed              int64
+	CSVDelim          string
+	CSVNoHeader       bool
+	CSVQuote          string
+	CSVEscape         string
+	DupKeys           string
+	JSONPath          string
+	CSVHeaderComment  string
+	NewlineRobust     bool
+	StdinTimeout      time.Duration
+	SortKeysRecursive bool
+	Out               string
+	Rotate            int
+	MaxLineBytes      int
+	CSVCRLF           bool
+	Flatten           bool
+	FlattenDelim      string
+	KeepEmpty         bool
+	OnEmpty           string
+	OutTemplate       string
+	Repeat            int
+	NoFallback        bool
+	XMLRoot           string
+	XMLItem           string
+	AllowInlineConfig bool
+	ConfigSection     bool
+	ProgressBar       bool
+	Workers           int
+	TemplateFile      string
+	TemplateStr       string
+	TemplateHeader    string
+	TemplateFooter    string
+	Transpose         bool
+	ErrorsFile        string
+
+	rejects *rejectFile // opened from ErrorsFile by main
+}
+
+// merge adds a config fragment to c. Output mappings and rules are appended
+// after those already loaded, and the fragment's settings replace c's where
+// the fragment sets them.
+func (c *Config) merge(fragment Config) {
+	if fragment.MatchRule != "" {
+		c.MatchRule = fragment.MatchRule
+	}
+	if fragment.CloneOriginal {
+		c.CloneOriginal = true
+	}
+	if fragment.CSVInferTypes {
+		c.CSVInferTypes = true
+	}
+	if fragment.FixedCols != "" {
+		c.FixedCols = fragment.FixedCols
+	}
+	if len(fragment.CSVColumns) > 0 {
+		c.CSVColumns = fragment.CSVColumns
+	}
+	c.KeepKeys = append(c.KeepKeys, fragment.KeepKeys...)
+	c.DropKeys = append(c.DropKeys, fragment.DropKeys...)
+	c.CommonOutput = append(c.CommonOutput, fragment.CommonOutput...)
+	c.SpecificOutputs = append(c.SpecificOutputs, fragment.SpecificOutputs...)
+}
+
+// OutputTarget is one output format and the file it is written to.
+// An empty Path means stdout.
+type OutputTarget struct {
+	Format string
+	Path   string
 }
 
 // AndCondition represents one condition in a rule's "and" list.
 type AndCondition struct {
-	Field   string  `yaml:"field"`
-	Eq      *string `yaml:"eq,omitempty"`
-	Matches *string `yaml:"matches,omitempty"`
+	Field    string   `yaml:"field"`
+	Eq       *string  `yaml:"eq,omitempty"`
+	Matches  *string  `yaml:"matches,omitempty"`
+	Contains *string  `yaml:"contains,omitempty"`
+	In       []string `yaml:"in,omitempty"`
+	Has      *string  `yaml:"has,omitempty"`
+	Gt       *bound   `yaml:"gt,omitempty"`
+	Lt       *bound   `yaml:"lt,omitempty"`
+	Gte      *bound   `yaml:"gte,omitempty"`
+	Lte      *bound   `yaml:"lte,omitempty"`
+	Not      bool     `yaml:"not,omitempty"`
+
+	matches *regexp.Regexp // Matches, compiled with the config
+}
+
+// compile compiles the condition's regex, if any.
+func (ac *AndCondition) compile() {
+	if ac.Matches != nil {
+		ac.matches = precompileRegex(*ac.Matches)
+	}
 }
 
-// Check returns true if the condition holds for the given record.
+// Check returns true if the condition holds for the given record, or, with
+// not, if it does not.
 func (ac *AndCondition) Check(record map[string]any) bool {
+	return ac.holds(record) != ac.Not
+}
+
+func (ac *AndCondition) holds(record map[string]any) bool {
 	val := getValueByPath(record, ac.Field)
+	if ac.Has != nil {
+		return hasElement(val, *ac.Has)
+	}
+	if ac.Gt != nil || ac.Lt != nil || ac.Gte != nil || ac.Lte != nil {
+		return inBounds(val, ac.Gt, ac.Lt, ac.Gte, ac.Lte)
+	}
 	strVal, ok := val.(string)
 	if !ok {
 		return false
@@ -40,27 +159,86 @@ func (ac *AndCondition) Check(record map[string]any) bool {
 		return strVal == *ac.Eq
 	}
 	if ac.Matches != nil {
-		re, err := regexp.Compile(*ac.Matches)
+		re, err := regexOrCompile(ac.matches, *ac.Matches)
 		if err != nil {
 			return false
 		}
 		return re.MatchString(strVal)
 	}
+	if ac.Contains != nil {
+		return strings.Contains(strVal, *ac.Contains)
+	}
+	if ac.In != nil {
+		return slices.Contains(ac.In, strVal)
+	}
 	return false
 }
 
 // SpecificOutputRule represents one specific rule.
 type SpecificOutputRule struct {
-	Field   string         `yaml:"field"`
-	Eq      *string        `yaml:"eq,omitempty"`
-	Matches *string        `yaml:"matches,omitempty"`
-	And     []AndCondition `yaml:"and,omitempty"`
-	Output  []OutputMap    `yaml:"output"`
+	Name      string         `yaml:"name,omitempty"`
+	Field     string         `yaml:"field"`
+	Eq        *string        `yaml:"eq,omitempty"`
+	Matches   *string        `yaml:"matches,omitempty"`
+	Contains  *string        `yaml:"contains,omitempty"`
+	In        []string       `yaml:"in,omitempty"`
+	Capture   string         `yaml:"capture,omitempty"`
+	Has       *string        `yaml:"has,omitempty"`
+	Gt        *bound         `yaml:"gt,omitempty"`
+	Lt        *bound         `yaml:"lt,omitempty"`
+	Gte       *bound         `yaml:"gte,omitempty"`
+	Lte       *bound         `yaml:"lte,omitempty"`
+	And       []AndCondition `yaml:"and,omitempty"`
+	Or        []AndCondition `yaml:"or,omitempty"`
+	Merge     string         `yaml:"merge,omitempty"`
+	OutputRaw string         `yaml:"output-raw,omitempty"`
+	Output    []OutputMap    `yaml:"output"`
+
+	matches, capture *regexp.Regexp // Matches and Capture, compiled with the config
+}
+
+// compile compiles the rule's regexes and decodes its output mappings.
+func (r *SpecificOutputRule) compile() error {
+	if r.Matches != nil {
+		r.matches = precompileRegex(*r.Matches)
+	}
+	r.capture = precompileRegex(r.Capture)
+	for i := range r.And {
+		r.And[i].compile()
+	}
+	for i := range r.Or {
+		r.Or[i].compile()
+	}
+	for _, om := range r.Output {
+		if err := compileSpecs(om); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Check returns true if the rule matches the given record.
 func (r *SpecificOutputRule) Check(record map[string]any) bool {
+	if r.Field == "" {
+		// A rule made only of "and"/"or" conditions has no value of its own.
+		// One with neither, e.g. from a misspelled key, matches nothing.
+		if len(r.And) == 0 && len(r.Or) == 0 {
+			return false
+		}
+		return r.checkConditions(record)
+	}
 	val := getValueByPath(record, r.Field)
+	if r.Has != nil && !hasElement(val, *r.Has) {
+		return false
+	}
+	bounded := r.Gt != nil || r.Lt != nil || r.Gte != nil || r.Lte != nil
+	if bounded && !inBounds(val, r.Gt, r.Lt, r.Gte, r.Lte) {
+		return false
+	}
+	// Arrays and numbers have no string value for the other checks.
+	if (r.Has != nil || bounded) && r.Eq == nil && r.Matches == nil && r.Contains == nil && r.In == nil && r.Capture == "" {
+		return r.checkConditions(record)
+	}
 	strVal, ok := val.(string)
 	if !ok {
 		return false
@@ -71,7 +249,7 @@ func (r *SpecificOutputRule) Check(record map[string]any) bool {
 		}
 	}
 	if r.Matches != nil {
-		re, err := regexp.Compile(*r.Matches)
+		re, err := regexOrCompile(r.matches, *r.Matches)
 		if err != nil {
 			return false
 		}
@@ -79,13 +257,180 @@ func (r *SpecificOutputRule) Check(record map[string]any) bool {
 			return false
 		}
 	}
-	// Check each "and" condition.
+	if r.Contains != nil && !strings.Contains(strVal, *r.Contains) {
+		return false
+	}
+	if r.In != nil && !slices.Contains(r.In, strVal) {
+		return false
+	}
+	if r.Capture != "" && r.Captures(record) == nil {
+		return false
+	}
+	return r.checkConditions(record)
+}
+
+// checkConditions returns true if every "and" condition holds for the record and,
+// when there are "or" conditions, at least one of them does.
+func (r *SpecificOutputRule) checkConditions(record map[string]any) bool {
 	for _, ac := range r.And {
 		if !ac.Check(record) {
 			return false
 		}
 	}
-	return true
+	if len(r.Or) == 0 {
+		return true
+	}
+	for _, oc := range r.Or {
+		if oc.Check(record) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate reports a rule without a field that is not made only of "and"/"or"
+// conditions, since its top-level comparisons would have no value to test.
+func (r *SpecificOutputRule) validate() error {
+	if r.Field != "" {
+		return nil
+	}
+	if len(r.And) == 0 && len(r.Or) == 0 {
+		return errors.New("a rule without a field needs and/or conditions")
+	}
+	if r.Eq != nil || r.Matches != nil || r.Contains != nil || r.In != nil || r.Capture != "" ||
+		r.Has != nil || r.Gt != nil || r.Lt != nil || r.Gte != nil || r.Lte != nil {
+		return errors.New("a rule without a field cannot use eq, matches, contains, in, capture, has, gt, lt, gte, or lte")
+	}
+	return nil
+}
+
+// bound is a gt, lt, gte, or lte value. YAML often quotes numbers, so a
+// numeric string such as "90" is accepted as well as a number.
+type bound float64
+
+func (b *bound) UnmarshalYAML(node *yaml.Node) error {
+	var f float64
+	if err := node.Decode(&f); err == nil {
+		*b = bound(f)
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(node.Value), 64); err == nil {
+			*b = bound(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("line %d: %q is not a number", node.Line, node.Value)
+}
+
+// inBounds returns true if val is a number, or a numeric string, that
+// satisfies every bound that is set. Non-numeric values are out of bounds.
+func inBounds(val any, gt, lt, gte, lte *bound) bool {
+	n, ok := toFloat(val)
+	if !ok {
+		return false
+	}
+	return (gt == nil || n > float64(*gt)) &&
+		(lt == nil || n < float64(*lt)) &&
+		(gte == nil || n >= float64(*gte)) &&
+		(lte == nil || n <= float64(*lte))
+}
+
+// hasElement returns true if val is an array with an element whose text is
+// want. Values that are not arrays have no elements.
+func hasElement(val any, want string) bool {
+	arr, ok := val.([]any)
+	if !ok {
+		return false
+	}
+	for _, elem := range arr {
+		if valueText(elem) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Captures returns the match of the rule's capture regex against its field:
+// the whole match followed by each group. It returns nil if there is no
+// capture regex or it does not match.
+func (r *SpecificOutputRule) Captures(record map[string]any) []string {
+	if r.Capture == "" {
+		return nil
+	}
+	strVal, ok := getValueByPath(record, r.Field).(string)
+	if !ok {
+		return nil
+	}
+	re, err := regexOrCompile(r.capture, r.Capture)
+	if err != nil {
+		return nil
+	}
+	return re.FindStringSubmatch(strVal)
+}
+
+// MappingDefinition describes how a single output value is derived from a
+// record, as opposed to an OutputMap that builds a nested output object.
+type MappingDefinition struct {
+	Src        string         `yaml:"src"`
+	Regex      string         `yaml:"regex,omitempty"`
+	Value      string         `yaml:"value,omitempty"`
+	LookupPath string         `yaml:"lookup-path,omitempty"`
+	Word       *int           `yaml:"word,omitempty"`
+	Sep        string         `yaml:"sep,omitempty"`
+	KeyRegex   string         `yaml:"key-regex,omitempty"`
+	ToBool     bool           `yaml:"to-bool,omitempty"`
+	Clamp      []float64      `yaml:"clamp,omitempty"`
+	SchemaSig  bool           `yaml:"schema-sig,omitempty"`
+	Object     OutputMap      `yaml:"object,omitempty"`
+	If         *AndCondition  `yaml:"if,omitempty"`
+	Then       any            `yaml:"then,omitempty"`
+	Else       any            `yaml:"else,omitempty"`
+	Switch     string         `yaml:"switch,omitempty"`
+	Cases      map[string]any `yaml:"cases,omitempty"`
+	Secret     string         `yaml:"secret,omitempty"`
+	From       string         `yaml:"from,omitempty"`
+	Normalize  string         `yaml:"normalize,omitempty"`
+	QueryParam string         `yaml:"query-param,omitempty"`
+	URLParse   bool           `yaml:"url-parse,omitempty"`
+	Domain     bool           `yaml:"domain,omitempty"`
+	Func       string         `yaml:"func,omitempty"`
+	Acronyms   []string       `yaml:"acronyms,omitempty"`
+	Mask       string         `yaml:"mask,omitempty"`
+	StripANSI  bool           `yaml:"strip-ansi,omitempty"`
+	CountMatch string         `yaml:"count-matches,omitempty"`
+	Lookup     map[string]any `yaml:"lookup,omitempty"`
+	Filter     *AndCondition  `yaml:"filter,omitempty"`
+	Coalesce   []string       `yaml:"coalesce,omitempty"`
+	Percent    []string       `yaml:"percent,omitempty"`
+	Precision  *int           `yaml:"precision,omitempty"`
+	PctSign    bool           `yaml:"percent-sign,omitempty"`
+	Substr     []int          `yaml:"substr,omitempty"`
+	AutoTime   bool           `yaml:"auto-time,omitempty"`
+	Age        bool           `yaml:"age,omitempty"`
+	Format     string         `yaml:"format,omitempty"`
+	RownumBy   string         `yaml:"rownum-by,omitempty"`
+	RunningSum string         `yaml:"running-sum,omitempty"`
+	AsArray    bool           `yaml:"as-array,omitempty"`
+	Unique     bool           `yaml:"unique,omitempty"`
+	Sort       string         `yaml:"sort,omitempty"`
+	Min        []string       `yaml:"min,omitempty"`
+	Max        []string       `yaml:"max,omitempty"`
+	PadLeft    []any          `yaml:"padleft,omitempty"`
+	PadRight   []any          `yaml:"padright,omitempty"`
+	Type       string         `yaml:"type,omitempty"`
+	Default    any            `yaml:"default,omitempty"`
+
+	spec                        OutputMap      // the OutputMap decoded by compileSpec
+	regex, countMatch, keyRegex *regexp.Regexp // compiled with the config
+}
+
+// Label identifies the rule in diagnostics by its name, or by its index when unnamed.
+func (r *SpecificOutputRule) Label(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return strconv.Itoa(index)
 }
 
 // FieldMapping is a helper type for storing a mapping key and its definition.
@@ -2,6 +2,8 @@ package main
 
 import (
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func ptr[T any](v T) *T {
@@ -57,6 +59,126 @@ func TestAndCondition_Check(t *testing.T) {
 			record: map[string]any{"count": 1},
 			want:   false,
 		},
+		{
+			name:   "has member",
+			ac:     AndCondition{Field: "roles", Has: ptr("admin")},
+			record: map[string]any{"roles": []any{"user", "admin"}},
+			want:   true,
+		},
+		{
+			name:   "has non-member",
+			ac:     AndCondition{Field: "roles", Has: ptr("admin")},
+			record: map[string]any{"roles": []any{"user"}},
+			want:   false,
+		},
+		{
+			name:   "has non-array field",
+			ac:     AndCondition{Field: "roles", Has: ptr("admin")},
+			record: map[string]any{"roles": "admin"},
+			want:   false,
+		},
+		{
+			name:   "gt float64",
+			ac:     AndCondition{Field: "amount", Gt: ptr[bound](1000.0)},
+			record: map[string]any{"amount": 1500.0},
+			want:   true,
+		},
+		{
+			name:   "gt numeric string",
+			ac:     AndCondition{Field: "amount", Gt: ptr[bound](1000.0)},
+			record: map[string]any{"amount": " 1000.5"},
+			want:   true,
+		},
+		{
+			name:   "gt boundary",
+			ac:     AndCondition{Field: "amount", Gt: ptr[bound](1000.0)},
+			record: map[string]any{"amount": "1000"},
+			want:   false,
+		},
+		{
+			name:   "gte and lte range",
+			ac:     AndCondition{Field: "amount", Gte: ptr[bound](10.0), Lte: ptr[bound](20.0)},
+			record: map[string]any{"amount": 20.0},
+			want:   true,
+		},
+		{
+			name:   "lt non-numeric string",
+			ac:     AndCondition{Field: "amount", Lt: ptr[bound](5.0)},
+			record: map[string]any{"amount": "n/a"},
+			want:   false,
+		},
+		{
+			name:   "contains match",
+			ac:     AndCondition{Field: "msg", Contains: ptr("timeout")},
+			record: map[string]any{"msg": "upstream timeout after 30s"},
+			want:   true,
+		},
+		{
+			name:   "contains mismatch",
+			ac:     AndCondition{Field: "msg", Contains: ptr("timeout")},
+			record: map[string]any{"msg": "ok"},
+			want:   false,
+		},
+		{
+			name:   "contains non-string",
+			ac:     AndCondition{Field: "msg", Contains: ptr("1")},
+			record: map[string]any{"msg": 1.0},
+			want:   false,
+		},
+		{
+			name:   "in match",
+			ac:     AndCondition{Field: "status", In: []string{"active", "pending", "trial"}},
+			record: map[string]any{"status": "trial"},
+			want:   true,
+		},
+		{
+			name:   "in mismatch",
+			ac:     AndCondition{Field: "status", In: []string{"active", "pending", "trial"}},
+			record: map[string]any{"status": "closed"},
+			want:   false,
+		},
+		{
+			name:   "in empty list",
+			ac:     AndCondition{Field: "status", In: []string{}},
+			record: map[string]any{"status": "active"},
+			want:   false,
+		},
+		{
+			name:   "in non-string",
+			ac:     AndCondition{Field: "code", In: []string{"1", "2"}},
+			record: map[string]any{"code": 1.0},
+			want:   false,
+		},
+		{
+			name:   "not eq on equal value",
+			ac:     AndCondition{Field: "state", Eq: ptr("done"), Not: true},
+			record: map[string]any{"state": "done"},
+			want:   false,
+		},
+		{
+			name:   "not eq on other value",
+			ac:     AndCondition{Field: "state", Eq: ptr("done"), Not: true},
+			record: map[string]any{"state": "running"},
+			want:   true,
+		},
+		{
+			name:   "not matches on matching value",
+			ac:     AndCondition{Field: "id", Matches: ptr("^tmp_"), Not: true},
+			record: map[string]any{"id": "tmp_1"},
+			want:   false,
+		},
+		{
+			name:   "not matches on other value",
+			ac:     AndCondition{Field: "id", Matches: ptr("^tmp_"), Not: true},
+			record: map[string]any{"id": "usr_1"},
+			want:   true,
+		},
+		{
+			name:   "not on missing field",
+			ac:     AndCondition{Field: "state", Eq: ptr("done"), Not: true},
+			record: map[string]any{},
+			want:   true,
+		},
 		{
 			name:   "no conditions set",
 			ac:     AndCondition{Field: "status"},
@@ -146,12 +268,216 @@ func TestSpecificOutputRule_Check(t *testing.T) {
 			record: map[string]any{"count": 1},
 			want:   false,
 		},
+		{
+			name:   "has member",
+			rule:   SpecificOutputRule{Field: "roles", Has: ptr("admin")},
+			record: map[string]any{"roles": []any{"admin", "user"}},
+			want:   true,
+		},
+		{
+			name:   "has stringified member",
+			rule:   SpecificOutputRule{Field: "ids", Has: ptr("7")},
+			record: map[string]any{"ids": []any{float64(3), float64(7)}},
+			want:   true,
+		},
+		{
+			name:   "has large number member",
+			rule:   SpecificOutputRule{Field: "ids", Has: ptr("1000000")},
+			record: map[string]any{"ids": []any{float64(3), float64(1000000)}},
+			want:   true,
+		},
+		{
+			name:   "has non-member",
+			rule:   SpecificOutputRule{Field: "roles", Has: ptr("admin")},
+			record: map[string]any{"roles": []any{"user"}},
+			want:   false,
+		},
+		{
+			name:   "has non-array field",
+			rule:   SpecificOutputRule{Field: "roles", Has: ptr("admin")},
+			record: map[string]any{"roles": "admin"},
+			want:   false,
+		},
+		{
+			name:   "has with and condition failing",
+			rule:   SpecificOutputRule{
+				Field: "roles", Has: ptr("admin"),
+				And: []AndCondition{{Field: "status", Eq: ptr("active")}},
+			},
+			record: map[string]any{"roles": []any{"admin"}, "status": "inactive"},
+			want:   false,
+		},
+		{
+			name:   "gt float64",
+			rule:   SpecificOutputRule{Field: "amount", Gt: ptr[bound](1000.0)},
+			record: map[string]any{"amount": 1000.01},
+			want:   true,
+		},
+		{
+			name:   "gt numeric string",
+			rule:   SpecificOutputRule{Field: "amount", Gt: ptr[bound](1000.0)},
+			record: map[string]any{"amount": "2500"},
+			want:   true,
+		},
+		{
+			name:   "lte float64 out of range",
+			rule:   SpecificOutputRule{Field: "amount", Lte: ptr[bound](10.0)},
+			record: map[string]any{"amount": 10.5},
+			want:   false,
+		},
+		{
+			name:   "gte with matches on numeric string",
+			rule:   SpecificOutputRule{Field: "amount", Gte: ptr[bound](100.0), Matches: ptr(`^\d+$`)},
+			record: map[string]any{"amount": "150"},
+			want:   true,
+		},
+		{
+			name:   "gte with eq on float64",
+			rule:   SpecificOutputRule{Field: "amount", Gte: ptr[bound](100.0), Eq: ptr("150")},
+			record: map[string]any{"amount": 150.0},
+			want:   false,
+		},
+		{
+			name:   "lt non-numeric",
+			rule:   SpecificOutputRule{Field: "amount", Lt: ptr[bound](5.0)},
+			record: map[string]any{"amount": true},
+			want:   false,
+		},
+		{
+			name:   "lt missing field",
+			rule:   SpecificOutputRule{Field: "amount", Lt: ptr[bound](5.0)},
+			record: map[string]any{},
+			want:   false,
+		},
+		{
+			name:   "or first condition",
+			rule:   SpecificOutputRule{
+				Field: "type", Eq: ptr("vm"),
+				Or: []AndCondition{
+					{Field: "region", Eq: ptr("us-east")},
+					{Field: "region", Eq: ptr("us-west")},
+				},
+			},
+			record: map[string]any{"type": "vm", "region": "us-east"},
+			want:   true,
+		},
+		{
+			name:   "or second condition",
+			rule:   SpecificOutputRule{
+				Field: "type", Eq: ptr("vm"),
+				Or: []AndCondition{
+					{Field: "region", Eq: ptr("us-east")},
+					{Field: "region", Eq: ptr("us-west")},
+				},
+			},
+			record: map[string]any{"type": "vm", "region": "us-west"},
+			want:   true,
+		},
+		{
+			name:   "or none matching",
+			rule:   SpecificOutputRule{
+				Field: "type", Eq: ptr("vm"),
+				Or: []AndCondition{
+					{Field: "region", Eq: ptr("us-east")},
+					{Field: "region", Eq: ptr("us-west")},
+				},
+			},
+			record: map[string]any{"type": "vm", "region": "eu-west"},
+			want:   false,
+		},
+		{
+			name:   "or matching but top-level failing",
+			rule:   SpecificOutputRule{
+				Field: "type", Eq: ptr("vm"),
+				Or: []AndCondition{{Field: "region", Eq: ptr("us-east")}},
+			},
+			record: map[string]any{"type": "disk", "region": "us-east"},
+			want:   false,
+		},
+		{
+			name:   "or matching but and failing",
+			rule:   SpecificOutputRule{
+				Field: "type", Eq: ptr("vm"),
+				And: []AndCondition{{Field: "status", Eq: ptr("active")}},
+				Or: []AndCondition{{Field: "region", Eq: ptr("us-east")}},
+			},
+			record: map[string]any{"type": "vm", "status": "stopped", "region": "us-east"},
+			want:   false,
+		},
+		{
+			name:   "or without field",
+			rule:   SpecificOutputRule{
+				Or: []AndCondition{{Field: "region", Eq: ptr("us-east")}, {Field: "zone", Matches: ptr("^us-")}},
+			},
+			record: map[string]any{"zone": "us-central1-a"},
+			want:   true,
+		},
+		{
+			name:   "contains match",
+			rule:   SpecificOutputRule{Field: "path", Contains: ptr("/admin/")},
+			record: map[string]any{"path": "/api/admin/users"},
+			want:   true,
+		},
+		{
+			name:   "contains mismatch",
+			rule:   SpecificOutputRule{Field: "path", Contains: ptr("/admin/")},
+			record: map[string]any{"path": "/api/users"},
+			want:   false,
+		},
+		{
+			name:   "contains with eq failing",
+			rule:   SpecificOutputRule{Field: "path", Eq: ptr("/admin"), Contains: ptr("admin")},
+			record: map[string]any{"path": "/admin/x"},
+			want:   false,
+		},
+		{
+			name:   "contains non-string",
+			rule:   SpecificOutputRule{Field: "roles", Contains: ptr("admin")},
+			record: map[string]any{"roles": []any{"admin"}},
+			want:   false,
+		},
+		{
+			name:   "in match",
+			rule:   SpecificOutputRule{Field: "status", In: []string{"active", "pending"}},
+			record: map[string]any{"status": "pending"},
+			want:   true,
+		},
+		{
+			name:   "in mismatch",
+			rule:   SpecificOutputRule{Field: "status", In: []string{"active", "pending"}},
+			record: map[string]any{"status": "closed"},
+			want:   false,
+		},
+		{
+			name:   "in empty list",
+			rule:   SpecificOutputRule{Field: "status", In: []string{}},
+			record: map[string]any{"status": "active"},
+			want:   false,
+		},
+		{
+			name:   "in non-string",
+			rule:   SpecificOutputRule{Field: "status", In: []string{"true"}},
+			record: map[string]any{"status": true},
+			want:   false,
+		},
+		{
+			name:   "in with matches",
+			rule:   SpecificOutputRule{Field: "status", In: []string{"active", "archived"}, Matches: ptr("^arch")},
+			record: map[string]any{"status": "active"},
+			want:   false,
+		},
 		{
 			name:   "no conditions set just field",
 			rule:   SpecificOutputRule{Field: "type"},
 			record: map[string]any{"type": "user"},
 			want:   true,  // because all eq/matches are nil, it passes the base checks
 		},
+		{
+			name:   "no field and no and/or",
+			rule:   SpecificOutputRule{},
+			record: map[string]any{"type": "user"},
+			want:   false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,3 +488,95 @@ func TestSpecificOutputRule_Check(t *testing.T) {
 		})
 	}
 }
+
+func TestSpecificOutputRule_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{
+			name: "field",
+			rule: "field: type\neq: user",
+		},
+		{
+			name: "only and/or",
+			rule: "and: [{field: type, eq: user}]\nor: [{field: region, eq: eu}]",
+		},
+		{
+			name:    "no field and no and/or",
+			rule:    "output: [{id: id}]",
+			wantErr: true,
+		},
+		{
+			name:    "misspelled keys",
+			rule:    "feild: type\nequals: user",
+			wantErr: true,
+		},
+		{
+			name:    "top-level eq without field",
+			rule:    "eq: user\nand: [{field: type, eq: user}]",
+			wantErr: true,
+		},
+		{
+			name:    "top-level matches without field",
+			rule:    "matches: ^u\nor: [{field: type, eq: user}]",
+			wantErr: true,
+		},
+		{
+			name:    "top-level gt without field",
+			rule:    "gt: 3\nand: [{field: age, gt: 1}]",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rule SpecificOutputRule
+			if err := yaml.Unmarshal([]byte(tt.rule), &rule); err != nil {
+				t.Fatalf("yaml.Unmarshal() error: %v", err)
+			}
+			if err := rule.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_bound_quotedNumbers(t *testing.T) {
+	var rule SpecificOutputRule
+	err := yaml.Unmarshal([]byte(`
+field: score
+gte: "90"
+lt: 100
+and:
+  - field: age
+    gt: "17.5"
+`), &rule)
+	if err != nil {
+		t.Fatalf("yaml.Unmarshal() error: %v", err)
+	}
+	if !rule.Check(map[string]any{"score": 95, "age": 18}) {
+		t.Error("Check() = false, want true for a score of 95 and an age of 18")
+	}
+	if rule.Check(map[string]any{"score": "89", "age": 18}) {
+		t.Error("Check() = true, want false for a score of 89")
+	}
+	if rule.Check(map[string]any{"score": 95, "age": 17}) {
+		t.Error("Check() = true, want false for an age of 17")
+	}
+
+	if err := yaml.Unmarshal([]byte("field: score\ngt: high\n"), &SpecificOutputRule{}); err == nil {
+		t.Error("expected an error for a bound that is not a number")
+	}
+}
+
+func TestSpecificOutputRule_Label(t *testing.T) {
+	named := SpecificOutputRule{Name: "audit"}
+	if got := named.Label(3); got != "audit" {
+		t.Errorf("Label() = %q, want %q", got, "audit")
+	}
+	unnamed := SpecificOutputRule{}
+	if got := unnamed.Label(3); got != "3" {
+		t.Errorf("Label() = %q, want %q", got, "3")
+	}
+}
@@ -4,10 +4,20 @@ import (
 	"bufio"
 	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math"
+	"os"
 	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
@@ -15,9 +25,50 @@ import (
 type RecordFormatter interface {
 	WriteHeader() error
 	WriteRecord(record map[string]any) error
+	// WriteRaw writes a pre-rendered line verbatim, bypassing record formatting.
+	WriteRaw(line string) error
 	WriteFooter() error
 }
 
+// writeRawLine writes a pre-rendered line followed by a newline.
+func writeRawLine(writer *bufio.Writer, line string) error {
+	_, err := writer.WriteString(line + "\n")
+	return err
+}
+
+// bufferedRaw is a raw line held by a buffering formatter until its footer,
+// to be written before the buffered record at index before.
+type bufferedRaw struct {
+	before int
+	line   string
+}
+
+// writeBuffered writes buffered records with the raw lines received between
+// them, in their original order. Each run of records between raw lines is
+// written by writeRun.
+func writeBuffered(writer *bufio.Writer, records []map[string]any, raws []bufferedRaw, writeRun func([]map[string]any) error) error {
+	start := 0
+	for _, raw := range raws {
+		if raw.before > start {
+			if err := writeRun(records[start:raw.before]); err != nil {
+				return err
+			}
+			start = raw.before
+		}
+		if err := writeRawLine(writer, raw.line); err != nil {
+			return err
+		}
+	}
+	if start < len(records) {
+		return writeRun(records[start:])
+	}
+	return nil
+}
+
+// ErrBufferLimit is returned by buffering formatters that would hold more
+// records in memory than the configured limit.
+var ErrBufferLimit = errors.New("output buffer limit exceeded")
+
 // NewFormatter creates a new RecordFormatter based on the provided config.
 func NewFormatter(config *Config, writer *bufio.Writer, inputType InputType) (RecordFormatter, error) {
 	isSingletonInput := inputType == SingletonInput
@@ -26,12 +77,22 @@ func NewFormatter(config *Config, writer *bufio.Writer, inputType InputType) (Re
 		return NewJSONFormatter(writer, isSingletonInput), nil
 	case "jsonl":
 		return NewJSONLFormatter(writer), nil
+	case "json-seq":
+		return NewJSONSeqFormatter(writer), nil
 	case "jsonp":
-		return NewJSONPFormatter(writer, isSingletonInput), nil
+		return NewJSONPFormatter(writer, isSingletonInput, config.JSONIndent), nil
 	case "yaml":
-		return NewYAMLFormatter(writer, inputType), nil
-	case "csv":
+		f := NewYAMLFormatter(writer, inputType, config.MaxBuffer)
+		f.sortKeys = config.SortKeysRecursive
+		return f, nil
+	case "csv", "tsv":
 		return NewCSVFormatter(writer, config), nil
+	case "toml":
+		return NewTOMLFormatter(writer, inputType, config.MaxBuffer), nil
+	case "template":
+		return NewTemplateFormatter(writer, config)
+	case "xml":
+		return NewXMLFormatter(writer, config.XMLRoot, config.XMLItem), nil
 	default:
 		return nil, fmt.Errorf("unsupported output format: %s", config.OutputFormat)
 	}
@@ -75,6 +136,22 @@ func (f *JSONFormatter) WriteRecord(record map[string]any) error {
 	return err
 }
 
+// WriteRaw writes the line in place of an array element, after the same
+// separator a record would get.
+func (f *JSONFormatter) WriteRaw(line string) error {
+	if f.isSingletonInput {
+		return writeRawLine(f.writer, line)
+	}
+	if !f.isFirst {
+		if _, err := f.writer.WriteString(",\n"); err != nil {
+			return err
+		}
+	}
+	f.isFirst = false
+	_, err := f.writer.WriteString(line)
+	return err
+}
+
 func (f *JSONFormatter) WriteFooter() error {
 	if f.isSingletonInput {
 		return nil // No footer for singleton output
@@ -108,20 +185,70 @@ func (f *JSONLFormatter) WriteRecord(record map[string]any) error {
 	return err
 }
 
+func (f *JSONLFormatter) WriteRaw(line string) error {
+	return writeRawLine(f.writer, line)
+}
+
 func (f *JSONLFormatter) WriteFooter() error {
 	return nil // No footer for JSONL
 }
 
+// ========
+// JSONSeqFormatter formats records as an RFC 7464 JSON text sequence: each
+// record is preceded by a record separator (0x1E) and followed by a newline.
+type JSONSeqFormatter struct {
+	writer *bufio.Writer
+}
+
+// recordSeparator is the RFC 7464 record separator character.
+const recordSeparator = 0x1E
+
+func NewJSONSeqFormatter(writer *bufio.Writer) *JSONSeqFormatter {
+	return &JSONSeqFormatter{writer: writer}
+}
+
+func (f *JSONSeqFormatter) WriteHeader() error {
+	return nil // No header for JSON text sequences
+}
+
+func (f *JSONSeqFormatter) WriteRecord(record map[string]any) error {
+	outBytes, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error marshaling JSON: %v", err)
+		return err
+	}
+	if err := f.writer.WriteByte(recordSeparator); err != nil {
+		return err
+	}
+	outBytes = append(outBytes, '\n')
+	_, err = f.writer.Write(outBytes)
+	return err
+}
+
+func (f *JSONSeqFormatter) WriteRaw(line string) error {
+	return writeRawLine(f.writer, line)
+}
+
+func (f *JSONSeqFormatter) WriteFooter() error {
+	return nil // No footer for JSON text sequences
+}
+
 // ========
 // JSONPFormatter formats records as a pretty-printed JSON array.
 type JSONPFormatter struct {
 	isFirst          bool
 	writer           *bufio.Writer
 	isSingletonInput bool
+	indent           string
 }
 
-func NewJSONPFormatter(writer *bufio.Writer, isSingletonInput bool) *JSONPFormatter {
-	return &JSONPFormatter{writer: writer, isFirst: true, isSingletonInput: isSingletonInput}
+// defaultJSONIndent is the jsonp indent when -json-indent is not given.
+const defaultJSONIndent = "  "
+
+// NewJSONPFormatter creates a JSONPFormatter that indents with indent. An
+// empty indent puts each value on its own line without indenting.
+func NewJSONPFormatter(writer *bufio.Writer, isSingletonInput bool, indent string) *JSONPFormatter {
+	return &JSONPFormatter{writer: writer, isFirst: true, isSingletonInput: isSingletonInput, indent: indent}
 }
 
 func (f *JSONPFormatter) WriteHeader() error {
@@ -141,7 +268,7 @@ func (f *JSONPFormatter) WriteRecord(record map[string]any) error {
 	}
 	f.isFirst = false
 
-	outBytes, err := json.MarshalIndent(record, "", "  ")
+	outBytes, err := json.MarshalIndent(record, "", f.indent)
 	if err != nil {
 		log.Printf("Error marshaling JSON: %v", err)
 		return err
@@ -150,6 +277,22 @@ func (f *JSONPFormatter) WriteRecord(record map[string]any) error {
 	return err
 }
 
+// WriteRaw writes the line in place of an array element, after the same
+// separator a record would get.
+func (f *JSONPFormatter) WriteRaw(line string) error {
+	if f.isSingletonInput {
+		return writeRawLine(f.writer, line)
+	}
+	if !f.isFirst {
+		if _, err := f.writer.WriteString(","); err != nil {
+			return err
+		}
+	}
+	f.isFirst = false
+	_, err := f.writer.WriteString(line)
+	return err
+}
+
 func (f *JSONPFormatter) WriteFooter() error {
 	if f.isSingletonInput {
 		return nil // No footer for singleton output
@@ -165,14 +308,18 @@ type YAMLFormatter struct {
 	inputType InputType
 	isFirst   bool
 	records   []map[string]any // Used only for ArrayInput
+	raws      []bufferedRaw    // Raw lines between the buffered records
+	maxBuffer int              // Limit on buffered records; 0 means unlimited
+	sortKeys  bool             // Order keys byte-wise at every level, like JSON
 }
 
-func NewYAMLFormatter(writer *bufio.Writer, inputType InputType) *YAMLFormatter {
+func NewYAMLFormatter(writer *bufio.Writer, inputType InputType, maxBuffer int) *YAMLFormatter {
 	return &YAMLFormatter{
 		writer:    writer,
 		inputType: inputType,
 		isFirst:   true,
 		records:   make([]map[string]any, 0),
+		maxBuffer: maxBuffer,
 	}
 }
 
@@ -184,7 +331,7 @@ func (f *YAMLFormatter) WriteRecord(record map[string]any) error {
 	switch f.inputType {
 	case SingletonInput:
 		// For a singleton, just marshal and write the one record.
-		outBytes, err := yaml.Marshal(record)
+		outBytes, err := f.marshal(record)
 		if err != nil {
 			log.Printf("Error marshaling YAML: %v", err)
 			return err
@@ -194,6 +341,9 @@ func (f *YAMLFormatter) WriteRecord(record map[string]any) error {
 
 	case ArrayInput:
 		// For an array, buffer the records to be written in the footer.
+		if f.maxBuffer > 0 && len(f.records) >= f.maxBuffer {
+			return fmt.Errorf("%w: more than %d records", ErrBufferLimit, f.maxBuffer)
+		}
 		f.records = append(f.records, record)
 		return nil
 
@@ -208,7 +358,7 @@ func (f *YAMLFormatter) WriteRecord(record map[string]any) error {
 		}
 		f.isFirst = false
 
-		outBytes, err := yaml.Marshal(record)
+		outBytes, err := f.marshal(record)
 		if err != nil {
 			log.Printf("Error marshaling YAML: %v", err)
 			return err
@@ -219,45 +369,531 @@ func (f *YAMLFormatter) WriteRecord(record map[string]any) error {
 	return nil
 }
 
+// marshal encodes v as YAML. yaml.v3 orders map keys "naturally" (a2 before
+// a10); with sortKeys every map is instead ordered byte-wise, matching JSON.
+func (f *YAMLFormatter) marshal(v any) ([]byte, error) {
+	if f.sortKeys {
+		if records, ok := v.([]map[string]any); ok {
+			sorted := make([]any, len(records))
+			for i, record := range records {
+				sorted[i] = sortKeysRecursive(record)
+			}
+			v = sorted
+		} else {
+			v = sortKeysRecursive(v)
+		}
+	}
+	return yaml.Marshal(v)
+}
+
+// sortedMap is a map whose entries are encoded in slice order.
+type sortedMap []sortedEntry
+
+type sortedEntry struct {
+	key   string
+	value any
+}
+
+func (m sortedMap) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, e := range m {
+		var key, value yaml.Node
+		if err := key.Encode(e.key); err != nil {
+			return nil, err
+		}
+		if err := value.Encode(e.value); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &key, &value)
+	}
+	return node, nil
+}
+
+// sortKeysRecursive replaces every map within v with a sortedMap whose keys
+// are in byte-wise order.
+func sortKeysRecursive(v any) any {
+	switch val := v.(type) {
+	case OutputMap:
+		return sortKeysRecursive(map[string]any(val))
+	case map[string]any:
+		sorted := make(sortedMap, 0, len(val))
+		for _, k := range slices.Sorted(maps.Keys(val)) {
+			sorted = append(sorted, sortedEntry{key: k, value: sortKeysRecursive(val[k])})
+		}
+		return sorted
+	case []any:
+		items := make([]any, len(val))
+		for i, item := range val {
+			items[i] = sortKeysRecursive(item)
+		}
+		return items
+	}
+	return v
+}
+
+// WriteRaw writes the line as its own document in a stream. For array input
+// it is buffered with the records, so that it keeps its place among them.
+func (f *YAMLFormatter) WriteRaw(line string) error {
+	switch f.inputType {
+	case ArrayInput:
+		f.raws = append(f.raws, bufferedRaw{before: len(f.records), line: line})
+		return nil
+	case StreamInput:
+		if !f.isFirst {
+			if _, err := f.writer.WriteString("---\n"); err != nil {
+				return err
+			}
+		}
+		f.isFirst = false
+	}
+	return writeRawLine(f.writer, line)
+}
+
 func (f *YAMLFormatter) WriteFooter() error {
 	if f.inputType == ArrayInput {
-		// If the input was an array, marshal the entire buffered slice into a single YAML document.
-		if len(f.records) > 0 {
-			outBytes, err := yaml.Marshal(f.records)
+		// If the input was an array, marshal the buffered records into a single
+		// YAML sequence, split only where raw lines were written between them.
+		return writeBuffered(f.writer, f.records, f.raws, func(records []map[string]any) error {
+			outBytes, err := f.marshal(records)
 			if err != nil {
 				log.Printf("Error marshaling YAML array: %v", err)
 				return err
 			}
 			_, err = f.writer.Write(outBytes)
 			return err
-		}
+		})
 	}
 	return nil // No footer for other types.
 }
 
+// ========
+// TOMLFormatter formats records as a TOML document: a single table for
+// singleton input, or otherwise an array of tables named by tomlRecordsKey.
+// TOML has no document stream, so stream input is buffered like an array.
+type TOMLFormatter struct {
+	writer    *bufio.Writer
+	inputType InputType
+	records   []map[string]any // Used for ArrayInput and StreamInput
+	raws      []bufferedRaw    // Raw lines between the buffered records
+	maxBuffer int              // Limit on buffered records; 0 means unlimited
+}
+
+// tomlRecordsKey names the array of tables holding the records.
+const tomlRecordsKey = "records"
+
+func NewTOMLFormatter(writer *bufio.Writer, inputType InputType, maxBuffer int) *TOMLFormatter {
+	return &TOMLFormatter{writer: writer, inputType: inputType, maxBuffer: maxBuffer}
+}
+
+func (f *TOMLFormatter) WriteHeader() error {
+	return nil // No header for TOML.
+}
+
+func (f *TOMLFormatter) WriteRecord(record map[string]any) error {
+	if f.inputType == SingletonInput {
+		return newTOMLEncoder(f.writer).Encode(tomlValue(record))
+	}
+	if f.maxBuffer > 0 && len(f.records) >= f.maxBuffer {
+		return fmt.Errorf("%w: more than %d records", ErrBufferLimit, f.maxBuffer)
+	}
+	f.records = append(f.records, record)
+	return nil
+}
+
+// WriteRaw buffers the line with the records, unless the input is a
+// singleton, so that it keeps its place among them.
+func (f *TOMLFormatter) WriteRaw(line string) error {
+	if f.inputType == SingletonInput {
+		return writeRawLine(f.writer, line)
+	}
+	f.raws = append(f.raws, bufferedRaw{before: len(f.records), line: line})
+	return nil
+}
+
+func (f *TOMLFormatter) WriteFooter() error {
+	return writeBuffered(f.writer, f.records, f.raws, func(records []map[string]any) error {
+		tables := make([]any, len(records))
+		for i, record := range records {
+			tables[i] = tomlValue(record)
+		}
+		return newTOMLEncoder(f.writer).Encode(map[string]any{tomlRecordsKey: tables})
+	})
+}
+
+// tomlValue prepares a value for the TOML encoder. TOML has no null, so null
+// map values are left out and null array elements become empty strings.
+// OutputMaps become plain maps so that they are encoded as tables, and whole
+// numbers become integers.
+func tomlValue(v any) any {
+	switch val := v.(type) {
+	case OutputMap:
+		return tomlValue(map[string]any(val))
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, child := range val {
+			if child != nil {
+				out[k] = tomlValue(child)
+			}
+		}
+		return out
+	case []any:
+		items := make([]any, len(val))
+		for i, item := range val {
+			if item == nil {
+				items[i] = ""
+			} else {
+				items[i] = tomlValue(item)
+			}
+		}
+		return items
+	case float64:
+		// JSON numbers decode as float64; keep whole numbers TOML integers.
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val)
+		}
+	}
+	return v
+}
+
+// newTOMLEncoder returns an encoder that writes tables without indentation.
+func newTOMLEncoder(writer *bufio.Writer) *toml.Encoder {
+	enc := toml.NewEncoder(writer)
+	enc.Indent = ""
+	return enc
+}
+
+// ========
+// TemplateFormatter formats each record by executing a text/template with the
+// record as dot. Optional header and footer templates run without data.
+type TemplateFormatter struct {
+	writer *bufio.Writer
+	record *template.Template
+	fields []string // Record keys the record template prints.
+	header *template.Template
+	footer *template.Template
+}
+
+// NewTemplateFormatter parses the record template from -tmpl or -tmpl-str and
+// the optional -tmpl-header and -tmpl-footer templates.
+func NewTemplateFormatter(writer *bufio.Writer, config *Config) (*TemplateFormatter, error) {
+	text := config.TemplateStr
+	if config.TemplateFile != "" {
+		// The path is intentionally supplied by the CLI user.
+		// #nosec G304
+		data, err := os.ReadFile(config.TemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil, errors.New("template output requires -tmpl or -tmpl-str")
+	}
+	f := &TemplateFormatter{writer: writer}
+	var err error
+	if f.record, err = template.New("record").Parse(text); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]bool)
+	for _, t := range f.record.Templates() {
+		if t.Tree != nil {
+			templateFields(t.Tree.Root, true, fields)
+		}
+	}
+	for k, fill := range fields {
+		if fill {
+			f.fields = append(f.fields, k)
+		}
+	}
+	slices.Sort(f.fields)
+	if config.TemplateHeader != "" {
+		if f.header, err = template.New("header").Parse(config.TemplateHeader); err != nil {
+			return nil, err
+		}
+	}
+	if config.TemplateFooter != "" {
+		if f.footer, err = template.New("footer").Parse(config.TemplateFooter); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *TemplateFormatter) WriteHeader() error {
+	return f.execute(f.header, nil)
+}
+
+// WriteRecord executes the record template. Keys the template uses that are
+// missing or null in the record are given as "", so that they print nothing
+// instead of "<no value>" and are still false in {{if}}.
+func (f *TemplateFormatter) WriteRecord(record map[string]any) error {
+	data := record
+	copied := false
+	for _, k := range f.fields {
+		if record[k] != nil {
+			continue
+		}
+		if !copied {
+			data = maps.Clone(record)
+			if data == nil {
+				data = make(map[string]any, len(f.fields))
+			}
+			copied = true
+		}
+		data[k] = ""
+	}
+	return f.execute(f.record, data)
+}
+
+func (f *TemplateFormatter) WriteRaw(line string) error {
+	return writeRawLine(f.writer, line)
+}
+
+func (f *TemplateFormatter) WriteFooter() error {
+	return f.execute(f.footer, nil)
+}
+
+// templateFields adds the record keys a template refers to, as .key or $.key,
+// to fields. dot reports whether dot is still the record; inside range and
+// with it is not, so only $.key counts there. A key that is ranged over is
+// set to false, since range skips a missing value but fails on "".
+func templateFields(node parse.Node, dot bool, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFields(child, dot, fields)
+		}
+	case *parse.ActionNode:
+		templateFields(n.Pipe, dot, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateFields(cmd, dot, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFields(arg, dot, fields)
+		}
+	case *parse.ChainNode:
+		templateFields(n.Node, dot, fields)
+	case *parse.FieldNode, *parse.VariableNode:
+		if k := recordField(n, dot); k != "" {
+			if _, ok := fields[k]; !ok {
+				fields[k] = true
+			}
+		}
+	case *parse.IfNode:
+		templateFields(n.Pipe, dot, fields)
+		templateFields(n.List, dot, fields)
+		templateFields(n.ElseList, dot, fields)
+	case *parse.RangeNode:
+		templateFields(n.Pipe, dot, fields)
+		if len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			if k := recordField(n.Pipe.Cmds[0].Args[0], dot); k != "" {
+				fields[k] = false
+			}
+		}
+		templateFields(n.List, false, fields)
+		templateFields(n.ElseList, dot, fields)
+	case *parse.WithNode:
+		templateFields(n.Pipe, dot, fields)
+		templateFields(n.List, false, fields)
+		templateFields(n.ElseList, dot, fields)
+	case *parse.TemplateNode:
+		templateFields(n.Pipe, dot, fields)
+	}
+}
+
+// recordField returns the record key that node, a .key or $.key, refers to,
+// or "" if it is anything else.
+func recordField(node parse.Node, dot bool) string {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		if dot && len(n.Ident) == 1 {
+			return n.Ident[0]
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) == 2 && n.Ident[0] == "$" {
+			return n.Ident[1]
+		}
+	}
+	return ""
+}
+
+// execute runs a template, if there is one, and ends non-empty output with a
+// newline unless it already ends with one.
+func (f *TemplateFormatter) execute(tmpl *template.Template, data any) error {
+	if tmpl == nil {
+		return nil
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return err
+	}
+	out := sb.String()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	_, err := f.writer.WriteString(out)
+	return err
+}
+
+// ========
+// XMLFormatter formats records as <item> elements under a root element.
+type XMLFormatter struct {
+	writer *bufio.Writer
+	root   string
+	item   string
+}
+
+func NewXMLFormatter(writer *bufio.Writer, root, item string) *XMLFormatter {
+	if root == "" {
+		root = "root"
+	}
+	if item == "" {
+		item = "item"
+	}
+	return &XMLFormatter{writer: writer, root: xmlName(root), item: xmlName(item)}
+}
+
+func (f *XMLFormatter) WriteHeader() error {
+	_, err := fmt.Fprintf(f.writer, "%s<%s>\n", xml.Header, f.root)
+	return err
+}
+
+func (f *XMLFormatter) WriteRecord(record map[string]any) error {
+	var sb strings.Builder
+	sb.WriteString("  ")
+	writeXMLElement(&sb, f.item, record)
+	sb.WriteString("\n")
+	_, err := f.writer.WriteString(sb.String())
+	return err
+}
+
+func (f *XMLFormatter) WriteRaw(line string) error {
+	return writeRawLine(f.writer, line)
+}
+
+func (f *XMLFormatter) WriteFooter() error {
+	_, err := fmt.Fprintf(f.writer, "</%s>\n", f.root)
+	return err
+}
+
+// writeXMLElement writes a value as an element. Map keys become child
+// elements in sorted order, each array element repeats the element, nulls
+// are empty elements, and other values are written as their JSON text.
+func writeXMLElement(sb *strings.Builder, name string, v any) {
+	if arr, ok := v.([]any); ok {
+		for _, elem := range arr {
+			writeXMLElement(sb, name, elem)
+		}
+		return
+	}
+	sb.WriteString("<" + name + ">")
+	switch val := v.(type) {
+	case map[string]any:
+		writeXMLChildren(sb, val)
+	case OutputMap:
+		writeXMLChildren(sb, val)
+	case string:
+		xml.EscapeText(sb, []byte(val))
+	case nil:
+	default:
+		text, err := json.Marshal(val)
+		if err != nil {
+			text = []byte(fmt.Sprint(val))
+		}
+		xml.EscapeText(sb, text)
+	}
+	sb.WriteString("</" + name + ">")
+}
+
+func writeXMLChildren(sb *strings.Builder, m map[string]any) {
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		writeXMLElement(sb, xmlName(k), m[k])
+	}
+}
+
+// xmlName makes a key usable as an element name: characters other than
+// letters, digits, "-", "_", and "." become "_", and a name that does not
+// start with a letter or "_" gets a "_" prefix.
+func xmlName(key string) string {
+	name := []rune(key)
+	for i, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || !(unicode.IsLetter(name[0]) || name[0] == '_') || strings.HasPrefix(strings.ToLower(string(name)), "xml") {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
 // ========
 // CSVFormatter formats records as CSV.
 type CSVFormatter struct {
-	csvWriter     *csv.Writer
+	writer        *bufio.Writer
+	csvWriter     csvRowWriter
 	headerOrder   []string
 	headerWritten bool
+	noHeader      bool
+	headerComment string
+	lineEnd       string
 }
 
 func NewCSVFormatter(writer *bufio.Writer, config *Config) *CSVFormatter {
+	lineEnd := "\n"
+	if config.CSVCRLF {
+		lineEnd = "\r\n"
+	}
+	headerOrder := config.CSVColumns
+	if len(headerOrder) == 0 && !config.Flatten {
+		// Configured keys name nested objects, not their flattened columns,
+		// so flattened output takes its header from the first record.
+		headerOrder = computeHeaderOrder(config)
+	}
 	return &CSVFormatter{
-		csvWriter:   csv.NewWriter(writer),
-		headerOrder: computeHeaderOrder(config),
+		writer:        writer,
+		csvWriter:     newCSVRowWriter(writer, config),
+		headerOrder:   headerOrder,
+		noHeader:      config.CSVNoHeader,
+		headerComment: config.CSVHeaderComment,
+		lineEnd:       lineEnd,
 	}
 }
 
 func (f *CSVFormatter) WriteHeader() error {
 	if len(f.headerOrder) > 0 {
-		f.headerWritten = true
-		return f.csvWriter.Write(f.headerOrder)
+		return f.writeHeaderRow()
 	}
 	return nil
 }
 
+// writeHeaderRow writes the header comment, if any, followed by the column
+// header. Each comment line is prefixed with "# " so that readers which skip
+// comment lines see the header as the first row. With -csv-no-header the
+// column order is fixed but nothing is written.
+func (f *CSVFormatter) writeHeaderRow() error {
+	f.headerWritten = true
+	if f.noHeader {
+		return nil
+	}
+	if f.headerComment != "" {
+		for _, line := range strings.Split(f.headerComment, "\n") {
+			if _, err := f.writer.WriteString("# " + line + f.lineEnd); err != nil {
+				return err
+			}
+		}
+	}
+	return f.csvWriter.Write(f.headerOrder)
+}
+
 func (f *CSVFormatter) WriteRecord(rec map[string]any) error {
 	if !f.headerWritten {
 		keys := make([]string, 0, len(rec))
@@ -266,8 +902,7 @@ func (f *CSVFormatter) WriteRecord(rec map[string]any) error {
 		}
 		slices.Sort(keys)
 		f.headerOrder = keys
-		f.headerWritten = true
-		if err := f.csvWriter.Write(f.headerOrder); err != nil {
+		if err := f.writeHeaderRow(); err != nil {
 			return err
 		}
 	}
@@ -293,11 +928,106 @@ func (f *CSVFormatter) WriteRecord(rec map[string]any) error {
 	return f.csvWriter.Write(row)
 }
 
+func (f *CSVFormatter) WriteRaw(line string) error {
+	// Flush pending rows so the raw line lands in order.
+	f.csvWriter.Flush()
+	if err := f.csvWriter.Error(); err != nil {
+		return err
+	}
+	return writeRawLine(f.writer, line)
+}
+
 func (f *CSVFormatter) WriteFooter() error {
 	f.csvWriter.Flush()
 	return f.csvWriter.Error()
 }
 
+// csvRowWriter is the subset of csv.Writer used by CSVFormatter.
+type csvRowWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// newCSVRowWriter returns a csv.Writer unless the config asks for quoting or
+// escaping that csv.Writer cannot produce.
+func newCSVRowWriter(writer *bufio.Writer, config *Config) csvRowWriter {
+	escape := '"'
+	if config.CSVEscape != "" {
+		escape = []rune(config.CSVEscape)[0]
+	}
+	if config.CSVQuote == "always" || escape != '"' {
+		return &quotingCSVWriter{writer: writer, comma: csvComma(config.OutputFormat, config.CSVDelim), escape: escape, alwaysQuote: config.CSVQuote == "always", useCRLF: config.CSVCRLF}
+	}
+	w := csv.NewWriter(writer)
+	w.Comma = csvComma(config.OutputFormat, config.CSVDelim)
+	w.UseCRLF = config.CSVCRLF
+	return w
+}
+
+// quotingCSVWriter writes CSV rows with optional always-quoting and a custom
+// escape character for quotes inside fields.
+type quotingCSVWriter struct {
+	writer      *bufio.Writer
+	comma       rune
+	escape      rune
+	alwaysQuote bool
+	useCRLF     bool
+	err         error
+}
+
+func (w *quotingCSVWriter) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
+	var sb strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			sb.WriteRune(w.comma)
+		}
+		if !w.alwaysQuote && !w.needsQuotes(field) {
+			sb.WriteString(field)
+			continue
+		}
+		sb.WriteByte('"')
+		for _, r := range field {
+			if r == '"' || (r == w.escape && w.escape != '"') {
+				sb.WriteRune(w.escape)
+			}
+			sb.WriteRune(r)
+		}
+		sb.WriteByte('"')
+	}
+	if w.useCRLF {
+		sb.WriteString("\r\n")
+	} else {
+		sb.WriteByte('\n')
+	}
+	_, w.err = w.writer.WriteString(sb.String())
+	return w.err
+}
+
+// needsQuotes reports whether a field must be quoted in minimal mode.
+func (w *quotingCSVWriter) needsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if strings.ContainsRune(field, w.comma) || strings.ContainsAny(field, "\"\r\n") || strings.ContainsRune(field, w.escape) {
+		return true
+	}
+	return field[0] == ' ' || field[0] == '\t'
+}
+
+func (w *quotingCSVWriter) Flush() {
+	if w.err == nil {
+		w.err = w.writer.Flush()
+	}
+}
+
+func (w *quotingCSVWriter) Error() error {
+	return w.err
+}
+
 // computeHeaderOrder computes the CSV header order based on the configuration.
 func computeHeaderOrder(config *Config) []string {
 	var headers []string
@@ -3,8 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/BurntSushi/toml"
 )
 
 func Test_computeHeaderOrder(t *testing.T) {
@@ -115,7 +120,7 @@ func TestJSONPFormatter_SingletonVsArray(t *testing.T) {
 	t.Run("singleton output", func(t *testing.T) {
 		var buf bytes.Buffer
 		writer := bufio.NewWriter(&buf)
-		formatter := NewJSONPFormatter(writer, true) // isSingletonInput = true
+		formatter := NewJSONPFormatter(writer, true, defaultJSONIndent) // isSingletonInput = true
 
 		// Write header, record, footer
 		if err := formatter.WriteHeader(); err != nil {
@@ -139,7 +144,7 @@ func TestJSONPFormatter_SingletonVsArray(t *testing.T) {
 	t.Run("array output", func(t *testing.T) {
 		var buf bytes.Buffer
 		writer := bufio.NewWriter(&buf)
-		formatter := NewJSONPFormatter(writer, false) // isSingletonInput = false
+		formatter := NewJSONPFormatter(writer, false, defaultJSONIndent) // isSingletonInput = false
 
 		// Write header, multiple records, footer
 		if err := formatter.WriteHeader(); err != nil {
@@ -164,6 +169,22 @@ func TestJSONPFormatter_SingletonVsArray(t *testing.T) {
 	})
 }
 
+func TestJSONPFormatter_indent(t *testing.T) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	formatter := NewJSONPFormatter(writer, true, "\t")
+
+	formatter.WriteHeader()
+	formatter.WriteRecord(map[string]any{"name": "Alice", "tags": []any{"a"}})
+	formatter.WriteFooter()
+	writer.Flush()
+
+	want := "{\n\t\"name\": \"Alice\",\n\t\"tags\": [\n\t\t\"a\"\n\t]\n}"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestYAMLFormatter(t *testing.T) {
 	testRecord1 := map[string]any{"name": "Alice", "age": 30}
 	testRecord2 := map[string]any{"name": "Bob", "age": 25}
@@ -171,7 +192,7 @@ func TestYAMLFormatter(t *testing.T) {
 	t.Run("singleton output", func(t *testing.T) {
 		var buf bytes.Buffer
 		writer := bufio.NewWriter(&buf)
-		formatter := NewYAMLFormatter(writer, SingletonInput)
+		formatter := NewYAMLFormatter(writer, SingletonInput, 0)
 
 		formatter.WriteHeader()
 		formatter.WriteRecord(testRecord1)
@@ -189,7 +210,7 @@ func TestYAMLFormatter(t *testing.T) {
 	t.Run("array output", func(t *testing.T) {
 		var buf bytes.Buffer
 		writer := bufio.NewWriter(&buf)
-		formatter := NewYAMLFormatter(writer, ArrayInput)
+		formatter := NewYAMLFormatter(writer, ArrayInput, 0)
 
 		formatter.WriteHeader()
 		formatter.WriteRecord(testRecord1)
@@ -207,7 +228,7 @@ func TestYAMLFormatter(t *testing.T) {
 	t.Run("stream output", func(t *testing.T) {
 		var buf bytes.Buffer
 		writer := bufio.NewWriter(&buf)
-		formatter := NewYAMLFormatter(writer, StreamInput)
+		formatter := NewYAMLFormatter(writer, StreamInput, 0)
 
 		formatter.WriteHeader()
 		formatter.WriteRecord(testRecord1)
@@ -223,6 +244,22 @@ func TestYAMLFormatter(t *testing.T) {
 	})
 }
 
+func TestYAMLFormatter_maxBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	formatter := NewYAMLFormatter(writer, ArrayInput, 2)
+
+	for i := range 2 {
+		if err := formatter.WriteRecord(map[string]any{"id": i}); err != nil {
+			t.Fatalf("record %d: unexpected error: %v", i, err)
+		}
+	}
+	err := formatter.WriteRecord(map[string]any{"id": 2})
+	if !errors.Is(err, ErrBufferLimit) {
+		t.Errorf("got error %v, want %v", err, ErrBufferLimit)
+	}
+}
+
 func TestJSONLFormatter(t *testing.T) {
 	testRecord1 := map[string]any{"name": "Alice", "age": 30}
 	testRecord2 := map[string]any{"name": "Bob", "age": 25}
@@ -294,6 +331,148 @@ common-output:
 	})
 }
 
+func TestFormatter_WriteRaw(t *testing.T) {
+	t.Run("jsonl", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewJSONLFormatter(writer)
+
+		formatter.WriteHeader()
+		formatter.WriteRecord(map[string]any{"id": 1})
+		formatter.WriteRaw("SUMMARY id=2")
+		formatter.WriteRecord(map[string]any{"id": 3})
+		formatter.WriteFooter()
+		writer.Flush()
+
+		want := `{"id":1}` + "\nSUMMARY id=2\n" + `{"id":3}` + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewCSVFormatter(writer, &Config{})
+
+		formatter.WriteHeader()
+		formatter.WriteRecord(map[string]any{"id": "1"})
+		formatter.WriteRaw("# SUMMARY id=2")
+		formatter.WriteRecord(map[string]any{"id": "3"})
+		formatter.WriteFooter()
+		writer.Flush()
+
+		want := "id\n1\n# SUMMARY id=2\n3\n"
+		if got := buf.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	write := func(format string, inputType InputType) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter, err := NewFormatter(&Config{OutputFormat: format, JSONIndent: defaultJSONIndent}, writer, inputType)
+		if err != nil {
+			t.Fatalf("NewFormatter() error: %v", err)
+		}
+		formatter.WriteHeader()
+		formatter.WriteRaw("# first")
+		formatter.WriteRecord(map[string]any{"id": 1})
+		formatter.WriteRecord(map[string]any{"id": 2})
+		formatter.WriteRaw("# between")
+		formatter.WriteRecord(map[string]any{"id": 3})
+		formatter.WriteRaw("# last")
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	tests := []struct {
+		name      string
+		format    string
+		inputType InputType
+		want      string
+	}{
+		{
+			name:      "json array",
+			format:    "json",
+			inputType: ArrayInput,
+			want:      "[\n# first,\n" + `{"id":1}` + ",\n" + `{"id":2}` + ",\n# between,\n" + `{"id":3}` + ",\n# last\n]",
+		},
+		{
+			name:      "jsonp array",
+			format:    "jsonp",
+			inputType: ArrayInput,
+			want:      "[# first,{\n  \"id\": 1\n},{\n  \"id\": 2\n},# between,{\n  \"id\": 3\n},# last]",
+		},
+		{
+			name:      "yaml array",
+			format:    "yaml",
+			inputType: ArrayInput,
+			want:      "# first\n- id: 1\n- id: 2\n# between\n- id: 3\n# last\n",
+		},
+		{
+			name:      "yaml stream",
+			format:    "yaml",
+			inputType: StreamInput,
+			want:      "# first\n---\nid: 1\n---\nid: 2\n---\n# between\n---\nid: 3\n---\n# last\n",
+		},
+		{
+			name:      "toml",
+			format:    "toml",
+			inputType: StreamInput,
+			want:      "# first\n[[records]]\nid = 1\n\n[[records]]\nid = 2\n# between\n[[records]]\nid = 3\n# last\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := write(tt.format, tt.inputType); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVFormatter_quoting(t *testing.T) {
+	write := func(cfg *Config, records ...map[string]any) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewCSVFormatter(writer, cfg)
+		formatter.WriteHeader()
+		for _, rec := range records {
+			formatter.WriteRecord(rec)
+		}
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	t.Run("always quote", func(t *testing.T) {
+		got := write(&Config{CSVQuote: "always"}, map[string]any{"name": `Al "Bo" Cy`, "n": "1"})
+		want := `"n","name"` + "\n" + `"1","Al ""Bo"" Cy"` + "\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("backslash escape", func(t *testing.T) {
+		got := write(&Config{CSVEscape: `\`}, map[string]any{"a": `say "hi"`, "b": `C:\tmp`, "c": "plain"})
+		want := "a,b,c\n" + `"say \"hi\"","C:\\tmp",plain` + "\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("minimal quoting matches encoding/csv", func(t *testing.T) {
+		rec := map[string]any{"a": "x,y", "b": " lead", "c": "line\nbreak", "d": ""}
+		custom := write(&Config{CSVQuote: "minimal", CSVEscape: `\`}, rec)
+		standard := write(&Config{}, rec)
+		if custom != standard {
+			t.Errorf("got %q, want %q", custom, standard)
+		}
+	})
+}
+
 func TestNewFormatter(t *testing.T) {
 	var buf bytes.Buffer
 	writer := bufio.NewWriter(&buf)
@@ -323,4 +502,411 @@ func TestNewFormatter(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestCSVFormatter_headerComment(t *testing.T) {
+	write := func(cfg *Config, records ...map[string]any) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewCSVFormatter(writer, cfg)
+		formatter.WriteHeader()
+		for _, rec := range records {
+			formatter.WriteRecord(rec)
+		}
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	t.Run("configured header", func(t *testing.T) {
+		cfg := &Config{
+			CSVHeaderComment: "generated by trmg",
+			CommonOutput:     []OutputMap{{"name": "name"}, {"n": "n"}},
+		}
+		got := write(cfg, map[string]any{"name": "a", "n": "1"})
+		want := "# generated by trmg\nname,n\na,1\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("header from first record", func(t *testing.T) {
+		cfg := &Config{CSVHeaderComment: "line one\nline two"}
+		got := write(cfg, map[string]any{"b": "2", "a": "1"}, map[string]any{"a": "3", "b": "4"})
+		want := "# line one\n# line two\na,b\n1,2\n3,4\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestFormatter_sortKeysRecursive(t *testing.T) {
+	record := map[string]any{
+		"b10": 1,
+		"b2":  2,
+		"nested": map[string]any{
+			"z":   "last",
+			"a10": []any{map[string]any{"y": 1, "x": 2}},
+			"a2":  OutputMap{"k2": true, "k10": false},
+		},
+	}
+	write := func(format string) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		config := &Config{OutputFormat: format, SortKeysRecursive: true}
+		formatter, err := NewFormatter(config, writer, StreamInput)
+		if err != nil {
+			t.Fatalf("NewFormatter: %v", err)
+		}
+		formatter.WriteHeader()
+		formatter.WriteRecord(record)
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	wantYAML := `b10: 1
+b2: 2
+nested:
+    a10:
+        - x: 2
+          "y": 1
+    a2:
+        k10: false
+        k2: true
+    z: last
+`
+	for i := 0; i < 3; i++ {
+		if got := write("yaml"); got != wantYAML {
+			t.Fatalf("yaml: got\n%s\nwant\n%s", got, wantYAML)
+		}
+	}
+
+	wantJSON := `{"b10":1,"b2":2,"nested":{"a10":[{"x":2,"y":1}],"a2":{"k10":false,"k2":true},"z":"last"}}` + "\n"
+	if got := write("jsonl"); got != wantJSON {
+		t.Errorf("jsonl: got %s, want %s", got, wantJSON)
+	}
+}
+
+func TestJSONSeqFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	formatter := NewJSONSeqFormatter(writer)
+	formatter.WriteHeader()
+	formatter.WriteRecord(map[string]any{"n": 1})
+	formatter.WriteRecord(map[string]any{"n": 2})
+	formatter.WriteFooter()
+	writer.Flush()
+
+	want := "\x1e{\"n\":1}\n\x1e{\"n\":2}\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCSVFormatter_crlf(t *testing.T) {
+	write := func(cfg *Config) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewCSVFormatter(writer, cfg)
+		formatter.WriteHeader()
+		formatter.WriteRecord(map[string]any{"a": "1", "b": "x"})
+		formatter.WriteRecord(map[string]any{"a": "2", "b": "y"})
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	if got, want := write(&Config{CSVCRLF: true}), "a,b\r\n1,x\r\n2,y\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := write(&Config{CSVCRLF: true, CSVQuote: "always", CSVHeaderComment: "c"}), "# c\r\n\"a\",\"b\"\r\n\"1\",\"x\"\r\n\"2\",\"y\"\r\n"; got != want {
+		t.Errorf("always quote: got %q, want %q", got, want)
+	}
+	if got, want := write(&Config{}), "a,b\n1,x\n2,y\n"; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+}
+
+func TestCSVFormatter_tsv(t *testing.T) {
+	write := func(cfg *Config) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewCSVFormatter(writer, cfg)
+		formatter.WriteHeader()
+		formatter.WriteRecord(map[string]any{"a": "1", "b": "x, y"})
+		formatter.WriteRecord(map[string]any{"a": "2", "b": "tab\there"})
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	if got, want := write(&Config{OutputFormat: "tsv"}), "a\tb\n1\tx, y\n2\t\"tab\there\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := write(&Config{OutputFormat: "tsv", CSVQuote: "always"}), "\"a\"\t\"b\"\n\"1\"\t\"x, y\"\n\"2\"\t\"tab\there\"\n"; got != want {
+		t.Errorf("always quote: got %q, want %q", got, want)
+	}
+}
+
+func TestCSVFormatter_delim(t *testing.T) {
+	cfg := &Config{OutputFormat: "csv", CSVDelim: ";", CSVQuote: "always"}
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	formatter := NewCSVFormatter(writer, cfg)
+	formatter.WriteHeader()
+	formatter.WriteRecord(map[string]any{"a": "1", "b": "x;y, z"})
+	formatter.WriteFooter()
+	writer.Flush()
+
+	if got, want := buf.String(), "\"a\";\"b\"\n\"1\";\"x;y, z\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The same delimiter reads the output back.
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	go readCSVInput(&buf, objs, inputTypeChan, Config{MatchRule: "all", InputFormat: "csv", CSVDelim: ";"})
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	if want := []map[string]any{{"a": "1", "b": "x;y, z"}}; !reflect.DeepEqual(results, want) {
+		t.Errorf("round trip: got %v, want %v", results, want)
+	}
+}
+
+func TestCSVFormatter_noHeader(t *testing.T) {
+	write := func(cfg *Config) string {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter := NewCSVFormatter(writer, cfg)
+		formatter.WriteHeader()
+		formatter.WriteRecord(map[string]any{"b": "x", "a": "1"})
+		formatter.WriteRecord(map[string]any{"a": "2"})
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String()
+	}
+
+	if got, want := write(&Config{CSVNoHeader: true, CSVHeaderComment: "c"}), "1,x\n2,\n"; got != want {
+		t.Errorf("header from first record: got %q, want %q", got, want)
+	}
+	cfg := mustConfig(t, "common-output:\n  - b: b\n  - a: a\n")
+	cfg.CSVNoHeader = true
+	if got, want := write(cfg), "x,1\n,2\n"; got != want {
+		t.Errorf("configured order: got %q, want %q", got, want)
+	}
+}
+
+func TestCSVFormatter_csvColumns(t *testing.T) {
+	cfg := mustConfig(t, `
+csv-columns: [id, status, note]
+common-output:
+  - id: id
+specific-outputs:
+  - field: kind
+    eq: a
+    output:
+      - extra: extra
+      - status: status
+`)
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	formatter := NewCSVFormatter(writer, cfg)
+	formatter.WriteHeader()
+	formatter.WriteRecord(map[string]any{"id": "1", "status": "ok", "extra": "dropped"})
+	formatter.WriteRecord(map[string]any{"id": "2"})
+	formatter.WriteFooter()
+	writer.Flush()
+
+	if got, want := buf.String(), "id,status,note\n1,ok,\n2,,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestXMLFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	formatter, err := NewFormatter(&Config{OutputFormat: "xml", XMLRoot: "events", XMLItem: "event"}, writer, ArrayInput)
+	if err != nil {
+		t.Fatalf("NewFormatter() error: %v", err)
+	}
+	formatter.WriteHeader()
+	formatter.WriteRecord(map[string]any{
+		"name":   "a<b & c>",
+		"count":  3.0,
+		"ok":     true,
+		"none":   nil,
+		"tags":   []any{"x", "y"},
+		"geo":    OutputMap{"lat": 1.5},
+		"1st id": "z",
+	})
+	formatter.WriteRecord(map[string]any{"name": "second"})
+	formatter.WriteFooter()
+	writer.Flush()
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n<events>\n" +
+		"  <event><_1st_id>z</_1st_id><count>3</count><geo><lat>1.5</lat></geo><name>a&lt;b &amp; c&gt;</name><none></none><ok>true</ok><tags>x</tags><tags>y</tags></event>\n" +
+		"  <event><name>second</name></event>\n" +
+		"</events>\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_xmlName(t *testing.T) {
+	for key, want := range map[string]string{
+		"name":      "name",
+		"a.b-c_d":   "a.b-c_d",
+		"has space": "has_space",
+		"9lives":    "_9lives",
+		"xmlns":     "_xmlns",
+		"":          "_",
+	} {
+		if got := xmlName(key); got != want {
+			t.Errorf("xmlName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestTOMLFormatter(t *testing.T) {
+	write := func(inputType InputType, maxBuffer int, records ...map[string]any) (string, error) {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter, err := NewFormatter(&Config{OutputFormat: "toml", MaxBuffer: maxBuffer}, writer, inputType)
+		if err != nil {
+			t.Fatalf("NewFormatter() error: %v", err)
+		}
+		formatter.WriteHeader()
+		for _, record := range records {
+			if err := formatter.WriteRecord(record); err != nil {
+				return "", err
+			}
+		}
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String(), nil
+	}
+
+	t.Run("singleton", func(t *testing.T) {
+		got, _ := write(SingletonInput, 0, map[string]any{"name": "app", "port": 8080.0, "db": OutputMap{"host": "h", "pass": nil}})
+		want := "name = \"app\"\nport = 8080\n\n[db]\nhost = \"h\"\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("array", func(t *testing.T) {
+		got, _ := write(ArrayInput, 0,
+			map[string]any{"id": 1.0, "ratio": 0.5, "tags": []any{"a", "b"}, "geo": OutputMap{"lat": 1.5}},
+			map[string]any{"id": 2.0},
+		)
+		var decoded map[string]any
+		if _, err := toml.Decode(got, &decoded); err != nil {
+			t.Fatalf("output is not valid TOML: %v\n%s", err, got)
+		}
+		want := map[string]any{"records": []map[string]any{
+			{"id": int64(1), "ratio": 0.5, "tags": []any{"a", "b"}, "geo": map[string]any{"lat": 1.5}},
+			{"id": int64(2)},
+		}}
+		if !reflect.DeepEqual(decoded, want) {
+			t.Errorf("decoded %v, want %v", decoded, want)
+		}
+	})
+
+	t.Run("stream is buffered", func(t *testing.T) {
+		got, _ := write(StreamInput, 0, map[string]any{"id": 1.0}, map[string]any{"id": 2.0})
+		want := "[[records]]\nid = 1\n\n[[records]]\nid = 2\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("buffer limit", func(t *testing.T) {
+		if _, err := write(ArrayInput, 1, map[string]any{"id": 1.0}, map[string]any{"id": 2.0}); !errors.Is(err, ErrBufferLimit) {
+			t.Errorf("got error %v, want ErrBufferLimit", err)
+		}
+	})
+}
+
+func TestTemplateFormatter(t *testing.T) {
+	write := func(cfg *Config) (string, error) {
+		cfg.OutputFormat = "template"
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		formatter, err := NewFormatter(cfg, writer, ArrayInput)
+		if err != nil {
+			return "", err
+		}
+		formatter.WriteHeader()
+		formatter.WriteRecord(map[string]any{"id": 1.0, "name": "Ada", "geo": OutputMap{"city": "London"}})
+		formatter.WriteRaw("-- raw")
+		formatter.WriteRecord(map[string]any{"id": 2.0, "name": "Grace", "geo": OutputMap{"city": "NYC"}})
+		formatter.WriteFooter()
+		writer.Flush()
+		return buf.String(), nil
+	}
+
+	t.Run("inline with header and footer", func(t *testing.T) {
+		got, err := write(&Config{
+			TemplateStr:    "| {{.id}} | {{.name}} | {{.geo.city}} |",
+			TemplateHeader: "| id | name | city |\n|---|---|---|",
+			TemplateFooter: "{{/* end */}}",
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		want := "| id | name | city |\n|---|---|---|\n| 1 | Ada | London |\n-- raw\n| 2 | Grace | NYC |\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "insert.tmpl")
+		os.WriteFile(path, []byte("INSERT INTO users VALUES ({{.id}}, '{{.name}}');\n"), 0o644)
+		got, err := write(&Config{TemplateFile: path, TemplateStr: "ignored"})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		want := "INSERT INTO users VALUES (1, 'Ada');\n-- raw\nINSERT INTO users VALUES (2, 'Grace');\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := write(&Config{}); err == nil {
+			t.Error("expected an error without a template")
+		}
+		if _, err := write(&Config{TemplateStr: "{{.id"}); err == nil {
+			t.Error("expected an error for an invalid template")
+		}
+		if _, err := write(&Config{TemplateFile: filepath.Join(t.TempDir(), "missing.tmpl")}); err == nil {
+			t.Error("expected an error for a missing template file")
+		}
+	})
+
+	t.Run("missing and null keys", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		tmpl := `{{.id}} [{{.email}}]{{if .note}} note={{.note}}{{end}}{{range .tags}} {{.}}{{$.id}}{{end}}`
+		formatter, err := NewFormatter(&Config{OutputFormat: "template", TemplateStr: tmpl}, writer, ArrayInput)
+		if err != nil {
+			t.Fatalf("NewFormatter() error: %v", err)
+		}
+		for _, record := range []map[string]any{
+			{"id": 1.0},
+			{"id": 2.0, "email": nil, "tags": []any{"a"}},
+			{"id": 3.0, "email": "a@b.c", "note": "hi"},
+		} {
+			if err := formatter.WriteRecord(record); err != nil {
+				t.Errorf("WriteRecord(%v) error: %v", record, err)
+			}
+		}
+		writer.Flush()
+		if got, want := buf.String(), "1 []\n2 [] a2\n3 [a@b.c] note=hi\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// zipFormat describes how entries of a ZIP archive are combined into a single
+// input stream for one input format.
+type zipFormat struct {
+	extensions []string // entry name extensions to read
+	separator  string   // written between consecutive entries
+}
+
+// zipFormats lists the input formats whose files can be concatenated.
+var zipFormats = map[string]zipFormat{
+	"jsonl": {extensions: []string{".jsonl", ".ndjson"}, separator: "\n"},
+	"yaml":  {extensions: []string{".yaml", ".yml"}, separator: "\n---\n"},
+}
+
+// openInput opens the configured input: stdin, a plain file, or a ZIP archive.
+func openInput(config Config) (io.ReadCloser, error) {
+	if config.InputFile == "" {
+		if config.StdinTimeout > 0 {
+			return io.NopCloser(newTimeoutReader(os.Stdin, config.StdinTimeout)), nil
+		}
+		return io.NopCloser(os.Stdin), nil
+	}
+	if strings.EqualFold(path.Ext(config.InputFile), ".zip") {
+		return openZipInput(config.InputFile, config.InputFormat)
+	}
+	// The path is intentionally supplied by the CLI user.
+	// #nosec G304
+	return os.Open(config.InputFile)
+}
+
+// inlineConfigPrefix starts a config directive on the first line of input.
+const inlineConfigPrefix = "#trmg:"
+
+// streamConfigKeys are the config keys that the input itself may set, with an
+// inline directive or a config section. Other settings, such as out and
+// errorsfile, choose what trmg reads and writes and are left to the command
+// line.
+var streamConfigKeys = []string{"match-rule", "clone-original", "common-output", "specific-outputs", "keep-keys", "drop-keys"}
+
+// unmarshalStreamConfig decodes config read from the input into config,
+// replacing the same top-level keys, after refusing any key not in
+// streamConfigKeys.
+func unmarshalStreamConfig(data []byte, config *Config) error {
+	var keys map[string]any
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		if !slices.Contains(streamConfigKeys, key) {
+			return fmt.Errorf("%q cannot be set from the input", key)
+		}
+	}
+	return yaml.Unmarshal(data, config)
+}
+
+// readInlineConfig checks the first line of input for a "#trmg: <yaml>"
+// directive. If there is one, its YAML replaces the same top-level keys of
+// config, as with -e, and the returned reader starts after that line.
+// Otherwise the returned reader yields the input unchanged. Only the keys in
+// streamConfigKeys may be set.
+func readInlineConfig(input io.Reader, config *Config) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	peeked, err := buffered.Peek(len(inlineConfigPrefix))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(peeked) != inlineConfigPrefix {
+		return buffered, nil
+	}
+	line, err := buffered.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	directive := strings.TrimPrefix(strings.TrimRight(line, "\r\n"), inlineConfigPrefix)
+	if err := unmarshalStreamConfig([]byte(directive), config); err != nil {
+		return nil, err
+	}
+	return buffered, nil
+}
+
+// configSectionMarker ends the config section of input read with -config-section.
+const configSectionMarker = "---TRMG---"
+
+// readConfigSection reads YAML config from the input up to a line holding only
+// "---TRMG---". Its keys replace the same top-level keys of config, as with
+// -e, and the returned reader yields the data after the marker line. Only the
+// keys in streamConfigKeys may be set.
+func readConfigSection(input io.Reader, config *Config) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	var section strings.Builder
+	for {
+		line, err := buffered.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == configSectionMarker {
+			break
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s line ends the config section", configSectionMarker)
+		}
+		if err != nil {
+			return nil, err
+		}
+		section.WriteString(line)
+	}
+	if err := unmarshalStreamConfig([]byte(section.String()), config); err != nil {
+		return nil, err
+	}
+	return buffered, nil
+}
+
+// rejectFile receives input that could not be parsed, verbatim, for
+// -errors-file. A nil *rejectFile discards everything, so readers can report
+// rejects without checking whether the flag is set.
+type rejectFile struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openRejectFile(path string) (*rejectFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	// The path is intentionally supplied by the CLI user.
+	// #nosec G304
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &rejectFile{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// write records one rejected line or value, ending it with a newline.
+func (r *rejectFile) write(raw []byte) {
+	if r == nil {
+		return
+	}
+	r.writer.Write(raw)
+	if len(raw) == 0 || raw[len(raw)-1] != '\n' {
+		r.writer.WriteByte('\n')
+	}
+}
+
+// close flushes and closes the file. Write errors surface here.
+func (r *rejectFile) close() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// progressInterval is how often -progress-bar redraws the bar.
+const progressInterval = 200 * time.Millisecond
+
+// progressWidth is the number of cells in the -progress-bar bar.
+const progressWidth = 30
+
+// progressReader counts the bytes read through it.
+type progressReader struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read.Add(int64(n))
+	return n, err
+}
+
+// startProgressBar wraps a file input so that a bar of bytes read against the
+// file size is redrawn on w until the returned stop function is called. Inputs
+// of unknown size, such as pipes or ZIP entries, are returned unchanged.
+func startProgressBar(input io.Reader, w io.Writer) (io.Reader, func()) {
+	file, ok := input.(*os.File)
+	if !ok {
+		return input, func() {}
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return input, func() {}
+	}
+	size := info.Size()
+	counter := &progressReader{r: input}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				renderProgress(w, counter.read.Load(), size)
+			case <-done:
+				renderProgress(w, counter.read.Load(), size)
+				fmt.Fprintln(w)
+				return
+			}
+		}
+	}()
+	return counter, func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// renderProgress redraws the progress bar in place on the current line.
+func renderProgress(w io.Writer, read, total int64) {
+	read = min(read, total)
+	filled := int(read * progressWidth / total)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+	fmt.Fprintf(w, "\r[%s] %3d%% %d/%d bytes", bar, read*100/total, read, total)
+}
+
+// ErrStdinTimeout is returned when no input arrives within -stdin-timeout.
+var ErrStdinTimeout = errors.New("no input received on stdin; pipe data in or use -f <file>")
+
+// timeoutReader fails its first Read if no data arrives within the timeout.
+// Once the first bytes arrive, reads pass straight through.
+type timeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+	started bool
+}
+
+func newTimeoutReader(r io.Reader, timeout time.Duration) *timeoutReader {
+	return &timeoutReader{r: r, timeout: timeout}
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	if t.started {
+		return t.r.Read(p)
+	}
+	type result struct {
+		buf []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Read into a private buffer: p must not be written after we return.
+		buf := make([]byte, len(p))
+		n, err := t.r.Read(buf)
+		done <- result{buf[:n], err}
+	}()
+	select {
+	case res := <-done:
+		t.started = true
+		return copy(p, res.buf), res.err
+	case <-time.After(t.timeout):
+		return 0, fmt.Errorf("%w within %v", ErrStdinTimeout, t.timeout)
+	}
+}
+
+// zipInput reads the matching entries of a ZIP archive as one stream.
+type zipInput struct {
+	io.Reader
+	archive *zip.ReadCloser
+	entries []io.ReadCloser
+}
+
+// openZipInput opens every archive entry matching the input format, in name
+// order, and combines them into a single stream. Other entries are skipped.
+func openZipInput(name, inputFormat string) (*zipInput, error) {
+	format, ok := zipFormats[inputFormat]
+	if !ok {
+		return nil, fmt.Errorf("zip archives are not supported for %s input", inputFormat)
+	}
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*zip.File
+	for _, f := range archive.File {
+		if !f.FileInfo().IsDir() && slices.Contains(format.extensions, strings.ToLower(path.Ext(f.Name))) {
+			files = append(files, f)
+		}
+	}
+	slices.SortFunc(files, func(a, b *zip.File) int { return strings.Compare(a.Name, b.Name) })
+
+	z := &zipInput{archive: archive}
+	var readers []io.Reader
+	for i, f := range files {
+		entry, err := f.Open()
+		if err != nil {
+			z.Close()
+			return nil, err
+		}
+		z.entries = append(z.entries, entry)
+		if i > 0 {
+			readers = append(readers, strings.NewReader(format.separator))
+		}
+		readers = append(readers, entry)
+	}
+	z.Reader = io.MultiReader(readers...)
+	return z, nil
+}
+
+func (z *zipInput) Close() error {
+	for _, entry := range z.entries {
+		entry.Close()
+	}
+	return z.archive.Close()
+}
+
+// unmarshalJSON decodes data into v like json.Unmarshal, but applies a policy
+// for objects that repeat a key: "error" rejects them, "warn" logs and keeps
+// the last value, "first" keeps the first value, and "last" (or "") keeps the
+// last value silently, which is the encoding/json behavior.
+func unmarshalJSON(data []byte, v any, policy string) error {
+	if policy == "" || policy == "last" {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	value, err := decodeJSONValue(dec, policy)
+	if err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON: unexpected data after top-level value")
+	}
+	// Duplicates are resolved; round-trip into the requested type.
+	resolved, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resolved, v)
+}
+
+// splitJSONValues splits data into its top-level JSON values, which may follow
+// one another directly or be separated by whitespace.
+func splitJSONValues(data []byte) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var values []json.RawMessage
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// decodeJSONValue reads one JSON value token by token, tracking object keys.
+func decodeJSONValue(dec *json.Decoder, policy string) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		obj := make(map[string]any)
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			val, err := decodeJSONValue(dec, policy)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := obj[key]; dup {
+				switch policy {
+				case "error":
+					return nil, fmt.Errorf("duplicate key %q", key)
+				case "warn":
+					log.Printf("Duplicate key %q in input object; keeping the last value", key)
+				case "first":
+					continue
+				}
+			}
+			obj[key] = val
+		}
+		_, err := dec.Token() // closing '}'
+		return obj, err
+	case '[':
+		arr := []any{}
+		for dec.More() {
+			val, err := decodeJSONValue(dec, policy)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		_, err := dec.Token() // closing ']'
+		return arr, err
+	}
+	return nil, fmt.Errorf("invalid JSON: unexpected %v", delim)
+}
+
+// defaultMaxLineBytes is the longest line or record the scanners accept when
+// -max-line-bytes is not set.
+const defaultMaxLineBytes = 16 << 20
+
+// newScanner returns a scanner whose token limit is -max-line-bytes rather
+// than bufio's 64KB default.
+func newScanner(input io.Reader, config Config) *bufio.Scanner {
+	scanner := bufio.NewScanner(input)
+	maxBytes := config.MaxLineBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxLineBytes
+	}
+	scanner.Buffer(make([]byte, 0, min(bufio.MaxScanTokenSize, maxBytes)), maxBytes)
+	return scanner
+}
+
+// newLineScanner returns a line scanner for line-oriented input formats. With
+// -input-newline-robust, a lone "\r" also ends a line, so input mixing "\r\n",
+// "\n", and "\r" terminators splits into the expected lines.
+func newLineScanner(input io.Reader, config Config) *bufio.Scanner {
+	scanner := newScanner(input, config)
+	if config.NewlineRobust {
+		scanner.Split(scanAnyLines)
+	}
+	return scanner
+}
+
+// scanAnyLines is a bufio.SplitFunc that splits on "\r\n", "\n", or "\r".
+func scanAnyLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// A trailing "\r" may be the first half of "\r\n"; read more.
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// scanJSONSeq is a bufio.SplitFunc that splits an RFC 7464 JSON text sequence
+// on record separators. Text before the first separator is returned as a
+// token too, so it is reported rather than silently dropped.
+func scanJSONSeq(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := 0
+	if len(data) > 0 && data[0] == recordSeparator {
+		start = 1
+	}
+	if i := bytes.IndexByte(data[start:], recordSeparator); i >= 0 {
+		return start + i, data[start : start+i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data[start:], nil
+	}
+	return 0, nil, nil
+}
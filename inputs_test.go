@@ -0,0 +1,779 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestZip(t *testing.T, entries map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "data.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("creating zip: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for entryName, content := range entries {
+		w, err := zw.Create(entryName)
+		if err != nil {
+			t.Fatalf("creating zip entry: %v", err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing zip: %v", err)
+	}
+	f.Close()
+	return name
+}
+
+func Test_openInput_zip(t *testing.T) {
+	name := writeTestZip(t, map[string]string{
+		"b.jsonl":    `{"id": 3}` + "\n",
+		"a.jsonl":    `{"id": 1}` + "\n" + `{"id": 2}`, // no trailing newline
+		"readme.txt": "not data",
+	})
+
+	config := Config{MatchRule: "all", InputFormat: "jsonl", InputFile: name}
+	input, err := openInput(config)
+	if err != nil {
+		t.Fatalf("openInput() error: %v", err)
+	}
+	defer input.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	go readJSONLInput(input, objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{
+		{"id": float64(1)},
+		{"id": float64(2)},
+		{"id": float64(3)},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func Test_openInput_zipUnsupportedFormat(t *testing.T) {
+	name := writeTestZip(t, map[string]string{"a.csv": "id\n1\n"})
+	if _, err := openInput(Config{InputFormat: "csv", InputFile: name}); err == nil {
+		t.Errorf("expected error for csv input from a zip archive")
+	}
+}
+
+func Test_unmarshalJSON_dupKeys(t *testing.T) {
+	input := []byte(`{"a": 1, "b": {"c": "x", "c": "y"}, "a": 2}`)
+	tests := []struct {
+		policy  string
+		want    map[string]any
+		wantErr bool
+		wantLog bool
+	}{
+		{policy: "last", want: map[string]any{"a": 2.0, "b": map[string]any{"c": "y"}}},
+		{policy: "first", want: map[string]any{"a": 1.0, "b": map[string]any{"c": "x"}}},
+		{policy: "warn", want: map[string]any{"a": 2.0, "b": map[string]any{"c": "y"}}, wantLog: true},
+		{policy: "error", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			var got map[string]any
+			err := unmarshalJSON(input, &got, tt.policy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if gotLog := logs.Len() > 0; gotLog != tt.wantLog {
+				t.Errorf("logged %q, wantLog %v", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func Test_unmarshalJSON_trailingData(t *testing.T) {
+	var got map[string]any
+	if err := unmarshalJSON([]byte(`{"a": 1} {"b": 2}`), &got, "error"); err == nil {
+		t.Errorf("expected error for trailing data, got %v", got)
+	}
+}
+
+func Test_newLineScanner_mixedTerminators(t *testing.T) {
+	input := "one\r\ntwo\nthree\rfour\r\rsix\r"
+	scan := func(config Config) []string {
+		var lines []string
+		scanner := newLineScanner(strings.NewReader(input), config)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("scan error: %v", err)
+		}
+		return lines
+	}
+
+	want := []string{"one", "two", "three", "four", "", "six"}
+	if got := scan(Config{NewlineRobust: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("robust: got %q, want %q", got, want)
+	}
+	// The standard scanner only splits on "\n".
+	want = []string{"one", "two", "three\rfour\r\rsix"}
+	if got := scan(Config{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("standard: got %q, want %q", got, want)
+	}
+}
+
+func Test_scanAnyLines_splitCRLF(t *testing.T) {
+	// A "\r" at the end of a buffer must wait for a possible "\n".
+	advance, token, err := scanAnyLines([]byte("a\r"), false)
+	if advance != 0 || token != nil || err != nil {
+		t.Errorf("got (%d, %q, %v), want a request for more data", advance, token, err)
+	}
+	advance, token, _ = scanAnyLines([]byte("a\r\nb"), false)
+	if advance != 3 || string(token) != "a" {
+		t.Errorf("got (%d, %q), want (3, \"a\")", advance, token)
+	}
+}
+
+func TestReadJSONLInput_newlineRobust(t *testing.T) {
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := Config{MatchRule: "all", NewlineRobust: true}
+
+	go readJSONLInput(strings.NewReader("{\"a\":1}\r\n{\"a\":2}\r{\"a\":3}\n"), objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{{"a": 1.0}, {"a": 2.0}, {"a": 3.0}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func Test_timeoutReader(t *testing.T) {
+	t.Run("no data", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("os.Pipe failed: %v", err)
+		}
+		defer w.Close()
+		defer r.Close()
+
+		_, err = io.ReadAll(newTimeoutReader(r, 20*time.Millisecond))
+		if !errors.Is(err, ErrStdinTimeout) {
+			t.Errorf("got error %v, want ErrStdinTimeout", err)
+		}
+	})
+
+	t.Run("data arrives", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("os.Pipe failed: %v", err)
+		}
+		defer r.Close()
+		go func() {
+			w.Write([]byte(`{"a": 1}`))
+			// Later reads are not subject to the timeout.
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("\n"))
+			w.Close()
+		}()
+
+		got, err := io.ReadAll(newTimeoutReader(r, 200*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(got) != "{\"a\": 1}\n" {
+			t.Errorf("got %q", got)
+		}
+	})
+}
+
+func TestReadJSONInput_concatenated(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantType InputType
+		want     []map[string]any
+	}{
+		{
+			name:     "no separators",
+			input:    `{"n":1}{"n":2}{"n":3}`,
+			wantType: StreamInput,
+			want:     []map[string]any{{"n": 1.0}, {"n": 2.0}, {"n": 3.0}},
+		},
+		{
+			name:     "whitespace separated",
+			input:    "{\"n\":1} {\"n\":2}\n\n{\"n\":3}",
+			wantType: StreamInput,
+			want:     []map[string]any{{"n": 1.0}, {"n": 2.0}, {"n": 3.0}},
+		},
+		{
+			name:     "single object",
+			input:    `{"n":1}`,
+			wantType: SingletonInput,
+			want:     []map[string]any{{"n": 1.0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := make(chan map[string]any, 10)
+			inputTypeChan := make(chan InputType, 1)
+
+			go readJSONInput(strings.NewReader(tt.input), objs, inputTypeChan, Config{MatchRule: "all"})
+
+			var results []map[string]any
+			for obj := range objs {
+				results = append(results, obj)
+			}
+			if gotType := <-inputTypeChan; gotType != tt.wantType {
+				t.Errorf("got input type %v, want %v", gotType, tt.wantType)
+			}
+			if !reflect.DeepEqual(results, tt.want) {
+				t.Errorf("got %v, want %v", results, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSONSeqInput(t *testing.T) {
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+
+	input := "\x1e{\"n\":1}\n\x1e{\"n\":2}\n"
+	go readJSONSeqInput(strings.NewReader(input), objs, inputTypeChan, Config{MatchRule: "all"})
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	if gotType := <-inputTypeChan; gotType != StreamInput {
+		t.Errorf("got input type %v, want %v", gotType, StreamInput)
+	}
+	want := []map[string]any{{"n": 1.0}, {"n": 2.0}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func Test_scanJSONSeq(t *testing.T) {
+	var tokens []string
+	scanner := bufio.NewScanner(strings.NewReader("\x1e{\"a\":1}\n\x1e\x1e{\"b\":\n\x1e[2]"))
+	scanner.Split(scanJSONSeq)
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	want := []string{"{\"a\":1}\n", "", "{\"b\":\n", "[2]"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("got %q, want %q", tokens, want)
+	}
+}
+
+func TestReadJSONLInput_longLine(t *testing.T) {
+	long := strings.Repeat("x", 100*1024) // over bufio's 64KB default
+	input := `{"big":"` + long + `"}` + "\n" + `{"n":2}` + "\n"
+
+	read := func(config Config) []map[string]any {
+		objs := make(chan map[string]any, 10)
+		inputTypeChan := make(chan InputType, 1)
+		go readJSONLInput(strings.NewReader(input), objs, inputTypeChan, config)
+		var results []map[string]any
+		for obj := range objs {
+			results = append(results, obj)
+		}
+		return results
+	}
+
+	got := read(Config{MatchRule: "all"})
+	want := []map[string]any{{"big": long}, {"n": 2.0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d records, want the long record and one more", len(got))
+	}
+}
+
+func Test_newScanner_maxLineBytes(t *testing.T) {
+	scanner := newScanner(strings.NewReader(strings.Repeat("x", 100)+"\n"), Config{MaxLineBytes: 50})
+	for scanner.Scan() {
+	}
+	if !errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		t.Errorf("got error %v, want bufio.ErrTooLong", scanner.Err())
+	}
+}
+
+func Test_main_inputFile(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(in, []byte("id,name\n1,one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.jsonl")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-f", in, "-i", "csv", "-o", "jsonl", "-out", out}
+
+	main()
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if want := `{"id":"1","name":"one"}` + "\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_readInlineConfig(t *testing.T) {
+	t.Run("directive", func(t *testing.T) {
+		config := Config{MatchRule: "all", CommonOutput: []OutputMap{{"from-flags": "x"}}}
+		input := "#trmg: {common-output: [{id: user.id}]}\r\n{\"user\": {\"id\": 7}}\n"
+		reader, err := readInlineConfig(strings.NewReader(input), &config)
+		if err != nil {
+			t.Fatalf("readInlineConfig() error: %v", err)
+		}
+		if want := []OutputMap{{"id": "user.id"}}; !reflect.DeepEqual(config.CommonOutput, want) {
+			t.Errorf("CommonOutput = %v, want %v", config.CommonOutput, want)
+		}
+		if config.MatchRule != "all" {
+			t.Errorf("MatchRule = %q, want it kept", config.MatchRule)
+		}
+		rest, _ := io.ReadAll(reader)
+		if want := "{\"user\": {\"id\": 7}}\n"; string(rest) != want {
+			t.Errorf("remaining input = %q, want %q", rest, want)
+		}
+	})
+
+	t.Run("no directive", func(t *testing.T) {
+		var config Config
+		input := "# a comment\n{}\n"
+		reader, err := readInlineConfig(strings.NewReader(input), &config)
+		if err != nil {
+			t.Fatalf("readInlineConfig() error: %v", err)
+		}
+		if rest, _ := io.ReadAll(reader); string(rest) != input {
+			t.Errorf("remaining input = %q, want it unchanged", rest)
+		}
+		if !reflect.DeepEqual(config, Config{}) {
+			t.Errorf("config changed to %+v", config)
+		}
+	})
+
+	t.Run("short input", func(t *testing.T) {
+		var config Config
+		reader, err := readInlineConfig(strings.NewReader("{}"), &config)
+		if err != nil {
+			t.Fatalf("readInlineConfig() error: %v", err)
+		}
+		if rest, _ := io.ReadAll(reader); string(rest) != "{}" {
+			t.Errorf("remaining input = %q", rest)
+		}
+	})
+
+	t.Run("invalid yaml", func(t *testing.T) {
+		var config Config
+		if _, err := readInlineConfig(strings.NewReader("#trmg: {common-output: [\n"), &config); err == nil {
+			t.Error("expected an error for an invalid directive")
+		}
+	})
+	t.Run("output settings refused", func(t *testing.T) {
+		for _, directive := range []string{"{out: /tmp/x.txt}", "{errorsfile: /tmp/x_err.txt}", "{common-output: [{id: id}], outputs: [{format: json, path: x}]}"} {
+			config := Config{MatchRule: "all"}
+			if _, err := readInlineConfig(strings.NewReader("#trmg: "+directive+"\n{}\n"), &config); err == nil {
+				t.Errorf("%s: expected an error", directive)
+			}
+			if config.Out != "" || config.ErrorsFile != "" || config.CommonOutput != nil {
+				t.Errorf("%s: config changed to %+v", directive, config)
+			}
+		}
+	})
+}
+
+func Test_main_allowInlineConfig(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.jsonl")
+	data := "#trmg: {common-output: [{id: user.id}]}\n" + `{"user": {"id": "u1", "ssn": "x"}}` + "\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.jsonl")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-f", in, "-i", "jsonl", "-o", "jsonl", "-out", out, "-allow-inline-config"}
+
+	main()
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if want := `{"id":"u1"}` + "\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_readConfigSection(t *testing.T) {
+	t.Run("section", func(t *testing.T) {
+		config := Config{MatchRule: "all"}
+		input := "common-output:\n- id: user.id\n---TRMG---\r\n{\"user\": {\"id\": 7}}\n"
+		reader, err := readConfigSection(strings.NewReader(input), &config)
+		if err != nil {
+			t.Fatalf("readConfigSection() error: %v", err)
+		}
+		if want := []OutputMap{{"id": "user.id"}}; !reflect.DeepEqual(config.CommonOutput, want) {
+			t.Errorf("CommonOutput = %v, want %v", config.CommonOutput, want)
+		}
+		if config.MatchRule != "all" {
+			t.Errorf("MatchRule = %q, want it kept", config.MatchRule)
+		}
+		rest, _ := io.ReadAll(reader)
+		if want := "{\"user\": {\"id\": 7}}\n"; string(rest) != want {
+			t.Errorf("remaining input = %q, want %q", rest, want)
+		}
+	})
+
+	t.Run("missing marker", func(t *testing.T) {
+		var config Config
+		if _, err := readConfigSection(strings.NewReader("match-rule: all\n{}\n"), &config); err == nil {
+			t.Error("expected an error without a marker line")
+		}
+	})
+
+	t.Run("invalid yaml", func(t *testing.T) {
+		var config Config
+		if _, err := readConfigSection(strings.NewReader("common-output: [\n---TRMG---\n"), &config); err == nil {
+			t.Error("expected an error for an invalid config section")
+		}
+	})
+	t.Run("output settings refused", func(t *testing.T) {
+		for _, section := range []string{"out: /tmp/x.txt", "errorsfile: /tmp/x_err.txt", "common-output: [{id: id}]\noutputs: [{format: json, path: x}]"} {
+			config := Config{MatchRule: "all"}
+			if _, err := readConfigSection(strings.NewReader(section+"\n---TRMG---\n{}\n"), &config); err == nil {
+				t.Errorf("%q: expected an error", section)
+			}
+			if config.Out != "" || config.ErrorsFile != "" || config.Outputs != nil || config.CommonOutput != nil {
+				t.Errorf("%q: config changed to %+v", section, config)
+			}
+		}
+	})
+}
+
+func Test_main_configSection(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "combined.txt")
+	data := "common-output:\n- id: id\n- name: name\n---TRMG---\nid,name,ssn\n1,Ann,x\n2,Bob,y\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.jsonl")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-f", in, "-i", "csv", "-o", "jsonl", "-out", out, "-config-section"}
+
+	main()
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if want := `{"id":"1","name":"Ann"}` + "\n" + `{"id":"2","name":"Bob"}` + "\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_main_invalidStreamConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		flag string
+		data string
+		want string
+	}{
+		{name: "config section", flag: "-config-section", data: "match-rule: first\n---TRMG---\n{}\n", want: "Invalid match-rule: first"},
+		{name: "inline config", flag: "-allow-inline-config", data: "#trmg: {specific-outputs: [{output: [{a: b}]}]}\n{}\n", want: "Invalid specific-outputs rule 0"},
+		{name: "inline output setting", flag: "-allow-inline-config", data: "#trmg: {out: x.txt}\n{}\n", want: `"out" cannot be set from the input`},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if os.Getenv("BE_CRASH_TEST_STREAM_CONFIG") == strconv.Itoa(i) {
+				flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+				os.Args = []string{os.Args[0], "-i", "jsonl", "-o", "jsonl", tt.flag}
+				main()
+				return
+			}
+
+			cmd := exec.Command(os.Args[0], "-test.run=^Test_main_invalidStreamConfig$/^"+strings.ReplaceAll(tt.name, " ", "_")+"$")
+			cmd.Env = append(os.Environ(), "BE_CRASH_TEST_STREAM_CONFIG="+strconv.Itoa(i))
+			cmd.Stdin = strings.NewReader(tt.data)
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err == nil {
+				t.Fatal("expected a non-zero exit for invalid config in the input")
+			}
+			if !strings.Contains(stderr.String(), tt.want) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.want)
+			}
+			if strings.Contains(stdout.String(), "{}") {
+				t.Errorf("stdout = %q, want no records", stdout.String())
+			}
+		})
+	}
+}
+
+func Test_startProgressBar(t *testing.T) {
+	data := strings.Repeat("x", 1000)
+	in := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var bar bytes.Buffer
+	reader, stop := startProgressBar(file, &bar)
+	counter, ok := reader.(*progressReader)
+	if !ok {
+		t.Fatalf("reader = %T, want *progressReader", reader)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if string(got) != data {
+		t.Errorf("read %d bytes, want the file unchanged", len(got))
+	}
+	if n := counter.read.Load(); n != 1000 {
+		t.Errorf("counted %d bytes, want 1000", n)
+	}
+	if want := "] 100% 1000/1000 bytes\n"; !strings.HasSuffix(bar.String(), want) {
+		t.Errorf("bar = %q, want it to end with %q", bar.String(), want)
+	}
+
+	t.Run("unknown size", func(t *testing.T) {
+		input := strings.NewReader(data)
+		reader, stop := startProgressBar(input, &bar)
+		stop()
+		if reader != io.Reader(input) {
+			t.Errorf("reader = %T, want the input unchanged", reader)
+		}
+	})
+}
+
+func Test_renderProgress(t *testing.T) {
+	var buf bytes.Buffer
+	renderProgress(&buf, 50, 200)
+	if want := "\r[=======                       ]  25% 50/200 bytes"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func Test_main_errorsFile(t *testing.T) {
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.jsonl")
+	data := `{"id": 1}` + "\n" + `{"id": 2,` + "\n" + `{"id": 3}` + "\n" + `not json` + "\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.jsonl")
+	bad := filepath.Join(dir, "bad.jsonl")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-f", in, "-i", "jsonl", "-o", "jsonl", "-out", out, "-errors-file", bad}
+
+	main()
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if want := `{"id":1}` + "\n" + `{"id":3}` + "\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	rejected, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatalf("reading errors file: %v", err)
+	}
+	if want := `{"id": 2,` + "\n" + `not json` + "\n"; string(rejected) != want {
+		t.Errorf("errors file = %q, want %q", rejected, want)
+	}
+}
+
+func Test_main_errorsFile_formats(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		data  string
+		want  string
+	}{
+		{
+			name:  "json stream",
+			flags: []string{"-i", "json"},
+			data:  `{"id": 1} {"id": 2, "id": 3} [1]`,
+			want:  "[1]\n",
+		},
+		{
+			name:  "json dup-keys error",
+			flags: []string{"-i", "json", "-dup-keys", "error"},
+			data:  `{"id": 1} {"id": 2, "id": 3}`,
+			want:  `{"id": 2, "id": 3}` + "\n",
+		},
+		{
+			name:  "jsonpath",
+			flags: []string{"-i", "json", "-jsonpath", "$.items[*]"},
+			data:  `{"items": [{"id": 1}, "text", [2, 3]]}`,
+			want:  `"text"` + "\n" + `[2,3]` + "\n",
+		},
+		{
+			name:  "jsonl",
+			flags: []string{"-i", "jsonl", "-dup-keys", "error"},
+			data:  `{"id": 1}` + "\n" + `{"id": 1, "id": 2}` + "\n" + `{"id": 3}` + "\n",
+			want:  `{"id": 1, "id": 2}` + "\n",
+		},
+		{
+			name:  "json-seq",
+			flags: []string{"-i", "json-seq"},
+			data:  "\x1e{\"id\": 1}\n\x1e{\"id\":\n\x1e{\"id\": 3}\n",
+			want:  `{"id":` + "\n",
+		},
+		{
+			name:  "yaml",
+			flags: []string{"-i", "yaml"},
+			data:  "id: 1\n---\n- a\n- b\n---\njust text\n---\nid: 2\n",
+			want:  "[a, b]\njust text\n",
+		},
+		{
+			name:  "yaml array",
+			flags: []string{"-i", "yaml"},
+			data:  "- id: 1\n- [x, {y: 2}]\n",
+			want:  "[x, {\"y\": 2}]\n",
+		},
+		{
+			name:  "csv",
+			flags: []string{"-i", "csv"},
+			data:  "id,name\n1,Ann\n2,Bob,extra\n3,C\"d\n4,Dee\n",
+			want:  "2,Bob,extra\n3,C\"d\n",
+		},
+		{
+			name:  "csv quoted newline",
+			flags: []string{"-i", "csv"},
+			data:  "id,note\n1,\"two\nlines\",x\n2,ok\n",
+			want:  "1,\"two\nlines\",x\n",
+		},
+		{
+			name:  "csv transposed",
+			flags: []string{"-i", "csv", "-transpose"},
+			data:  "key,value\nid,1\nname,Ann,extra\n",
+			want:  "name,Ann,extra\n",
+		},
+		{
+			name:  "logfmt",
+			flags: []string{"-i", "logfmt"},
+			data:  "a=1\nmsg=\"open\nb=2\n",
+			want:  "msg=\"open\n",
+		},
+		{
+			name:  "fixed",
+			flags: []string{"-i", "fixed", "-fixed-cols", "id:0-2,name:2-8"},
+			data:  "1 Ann\n2\n",
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origArgs := os.Args
+			origCommandLine := flag.CommandLine
+			defer func() {
+				os.Args = origArgs
+				flag.CommandLine = origCommandLine
+			}()
+
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			if err := os.WriteFile(in, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			bad := filepath.Join(dir, "bad")
+
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			os.Args = append([]string{os.Args[0], "-f", in, "-o", "jsonl", "-out", filepath.Join(dir, "out"), "-errors-file", bad}, tt.flags...)
+
+			main()
+
+			rejected, err := os.ReadFile(bad)
+			if err != nil {
+				t.Fatalf("reading errors file: %v", err)
+			}
+			if string(rejected) != tt.want {
+				t.Errorf("errors file = %q, want %q", rejected, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rejectFile_nil(t *testing.T) {
+	var r *rejectFile
+	r.write([]byte("ignored"))
+	if err := r.close(); err != nil {
+		t.Errorf("close() on nil = %v", err)
+	}
+}
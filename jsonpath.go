@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one segment of a parsed JSONPath expression.
+type jsonPathStep struct {
+	key      string // object member name, when not wildcard or indexed
+	index    int    // array index; negative values count from the end
+	indexed  bool
+	wildcard bool // every member of an object or element of an array
+}
+
+// parseJSONPath parses the subset of JSONPath that trmg supports: a leading
+// "$" followed by ".name", "['name']", "[n]", "[*]", and ".*" segments.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+	if !ok {
+		return nil, fmt.Errorf("JSONPath %q must start with $", path)
+	}
+	var steps []jsonPathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("JSONPath %q has an empty member name", path)
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{key: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath %q has an unclosed [", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("JSONPath %q has an invalid subscript [%s]", path, inner)
+				}
+				steps = append(steps, jsonPathStep{index: n, indexed: true})
+			}
+		default:
+			return nil, fmt.Errorf("JSONPath %q has unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// selectJSONPath returns every value in doc that the path selects, in
+// document order for arrays. Steps that do not match a value select nothing.
+func selectJSONPath(doc any, steps []jsonPathStep) []any {
+	current := []any{doc}
+	for _, step := range steps {
+		var next []any
+		for _, v := range current {
+			switch node := v.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, k := range slices.Sorted(maps.Keys(node)) {
+						next = append(next, node[k])
+					}
+				} else if child, ok := node[step.key]; ok && !step.indexed {
+					next = append(next, child)
+				}
+			case []any:
+				if step.wildcard {
+					next = append(next, node...)
+				} else if step.indexed {
+					i := step.index
+					if i < 0 {
+						i += len(node)
+					}
+					if i >= 0 && i < len(node) {
+						next = append(next, node[i])
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
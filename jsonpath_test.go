@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_selectJSONPath(t *testing.T) {
+	doc := map[string]any{
+		"response": map[string]any{
+			"items": []any{
+				map[string]any{"id": "a", "tags": []any{"x", "y"}},
+				map[string]any{"id": "b", "tags": []any{"z"}},
+			},
+			"meta": map[string]any{"count": 2.0, "next": "n"},
+		},
+	}
+	tests := []struct {
+		path string
+		want []any
+	}{
+		{path: "$", want: []any{doc}},
+		{path: "$.response.items[*].id", want: []any{"a", "b"}},
+		{path: "$.response.items[1].id", want: []any{"b"}},
+		{path: "$.response.items[-1].id", want: []any{"b"}},
+		{path: "$['response'][\"items\"][0].tags[*]", want: []any{"x", "y"}},
+		{path: "$.response.meta.*", want: []any{2.0, "n"}},
+		{path: "$.response.items[5]", want: nil},
+		{path: "$.missing.items[*]", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			steps, err := parseJSONPath(tt.path)
+			if err != nil {
+				t.Fatalf("parseJSONPath: %v", err)
+			}
+			if got := selectJSONPath(doc, steps); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseJSONPath_invalid(t *testing.T) {
+	for _, path := range []string{"response.items", "$.items[", "$.items[x]", "$..items", "$items"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("%q: expected error", path)
+		}
+	}
+}
+
+func TestReadJSONInput_jsonPath(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte(`{"response": {"items": [{"id": 1}, "skipped", {"id": 2}]}}`))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := Config{MatchRule: "all", JSONPath: "$.response.items[*]"}
+
+	go readJSONInput(r, objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+
+	if gotType := <-inputTypeChan; gotType != ArrayInput {
+		t.Errorf("got input type %v, want %v", gotType, ArrayInput)
+	}
+	want := []map[string]any{{"id": 1.0}, {"id": 2.0}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// logfmtState is a state of the logfmt line parser.
+type logfmtState int
+
+const (
+	logfmtBetween logfmtState = iota // skipping spaces between pairs
+	logfmtKey                        // reading a key
+	logfmtValue                      // reading a bare value after "="
+	logfmtQuoted                     // reading a quoted value
+	logfmtEscape                     // after a backslash in a quoted value
+)
+
+// parseLogfmt parses one logfmt line of space-separated key=value pairs.
+// Values may be double-quoted, with backslash escapes for quotes, backslashes,
+// \n, \r, and \t. "key=" gives an empty string, and a key on its own gives
+// true. A repeated key keeps its last value.
+func parseLogfmt(line string) (map[string]any, error) {
+	record := make(map[string]any)
+	state := logfmtBetween
+	var key, value strings.Builder
+	endPair := func(val any) {
+		record[key.String()] = val
+		key.Reset()
+		value.Reset()
+		state = logfmtBetween
+	}
+	for i, r := range line {
+		switch state {
+		case logfmtBetween:
+			switch {
+			case r == ' ' || r == '\t':
+			case r == '=' || r == '"':
+				return nil, fmt.Errorf("unexpected %q at column %d", r, i+1)
+			default:
+				key.WriteRune(r)
+				state = logfmtKey
+			}
+		case logfmtKey:
+			switch r {
+			case ' ', '\t':
+				endPair(true)
+			case '=':
+				state = logfmtValue
+			case '"':
+				return nil, fmt.Errorf("unexpected %q in key at column %d", r, i+1)
+			default:
+				key.WriteRune(r)
+			}
+		case logfmtValue:
+			switch {
+			case r == ' ' || r == '\t':
+				endPair(value.String())
+			case r == '"' && value.Len() == 0:
+				state = logfmtQuoted
+			default:
+				value.WriteRune(r)
+			}
+		case logfmtQuoted:
+			switch r {
+			case '\\':
+				state = logfmtEscape
+			case '"':
+				endPair(value.String())
+			default:
+				value.WriteRune(r)
+			}
+		case logfmtEscape:
+			switch r {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteRune(r)
+			}
+			state = logfmtQuoted
+		}
+	}
+	switch state {
+	case logfmtKey:
+		endPair(true)
+	case logfmtValue:
+		endPair(value.String())
+	case logfmtQuoted, logfmtEscape:
+		return nil, fmt.Errorf("unterminated quoted value for key %q", key.String())
+	}
+	return record, nil
+}
+
+func readLogfmtInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+	defer close(objs)
+	defer close(inputTypeChan)
+
+	inputTypeChan <- StreamInput
+
+	scanner := newLineScanner(input, config)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		record, err := parseLogfmt(line)
+		if err != nil {
+			log.Printf("Error parsing logfmt line: %v", err)
+			config.rejects.write([]byte(line))
+			continue
+		}
+		sendProcessed(record, objs, config)
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf("Error reading logfmt input: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseLogfmt(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    map[string]any
+		wantErr bool
+	}{
+		{
+			name: "bare values",
+			line: "level=info msg=started port=8080",
+			want: map[string]any{"level": "info", "msg": "started", "port": "8080"},
+		},
+		{
+			name: "quoted value with spaces",
+			line: `msg="disk full" level=error`,
+			want: map[string]any{"msg": "disk full", "level": "error"},
+		},
+		{
+			name: "escaped quotes",
+			line: `key="a \"b\" c"`,
+			want: map[string]any{"key": `a "b" c`},
+		},
+		{
+			name: "escaped backslash and newline",
+			line: `path="C:\\tmp" text="one\ntwo"`,
+			want: map[string]any{"path": `C:\tmp`, "text": "one\ntwo"},
+		},
+		{
+			name: "empty values",
+			line: `key= other="" last=`,
+			want: map[string]any{"key": "", "other": "", "last": ""},
+		},
+		{
+			name: "keys without values",
+			line: "flag level=warn debug",
+			want: map[string]any{"flag": true, "level": "warn", "debug": true},
+		},
+		{
+			name: "extra spacing",
+			line: "  a=1 \t b=2  ",
+			want: map[string]any{"a": "1", "b": "2"},
+		},
+		{
+			name: "equals in value",
+			line: "query=a=b",
+			want: map[string]any{"query": "a=b"},
+		},
+		{
+			name: "repeated key keeps last",
+			line: "a=1 a=2",
+			want: map[string]any{"a": "2"},
+		},
+		{
+			name:    "unterminated quote",
+			line:    `msg="never ends`,
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			line:    "=value",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLogfmt(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLogfmt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLogfmtInput(t *testing.T) {
+	input := "level=info msg=\"a \\\"b\\\" c\"\n\nbroken=\"\nflag id=\n"
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+
+	go readLogfmtInput(strings.NewReader(input), objs, inputTypeChan, Config{MatchRule: "all"})
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{
+		{"level": "info", "msg": `a "b" c`},
+		{"flag": true, "id": ""},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+	if it := <-inputTypeChan; it != StreamInput {
+		t.Errorf("input type = %v, want StreamInput", it)
+	}
+}
@@ -2,16 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"maps"
+	"math"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
 )
@@ -27,59 +36,294 @@ const (
 
 func main() {
 	config := getConfig()
+	// Records that could not be written are logged as they happen; the run
+	// then fails once everything else has been written and closed.
+	dropped := 0
+	defer func() {
+		if dropped > 0 {
+			fatalf("%d record(s) could not be written", dropped)
+		}
+	}()
 	objs := make(chan map[string]any, 16)
 	inputTypeChan := make(chan InputType, 1)
 
+	input, err := openInput(config)
+	if err != nil {
+		fatalf("Error opening input: %v", err)
+	}
+	defer input.Close()
+
+	var reader io.Reader = input
+	stopProgress := func() {}
+	if config.ProgressBar && config.InputFile != "" {
+		reader, stopProgress = startProgressBar(input, os.Stderr)
+	}
+	if config.ConfigSection {
+		reader, err = readConfigSection(reader, &config)
+		if err != nil {
+			fatalf("Error reading config section: %v", err)
+		}
+		if err := validateConfig(&config); err != nil {
+			fatalf("Error in config section: %v", err)
+		}
+	}
+	if config.AllowInlineConfig {
+		reader, err = readInlineConfig(reader, &config)
+		if err != nil {
+			fatalf("Error reading inline config: %v", err)
+		}
+		if err := validateConfig(&config); err != nil {
+			fatalf("Error in inline config: %v", err)
+		}
+	}
+
+	config.rejects, err = openRejectFile(config.ErrorsFile)
+	if err != nil {
+		fatalf("Error opening errors file: %v", err)
+	}
+	defer func() {
+		if err := config.rejects.close(); err != nil {
+			fatalf("Error writing errors file: %v", err)
+		}
+	}()
+
+	// With -workers, readers send raw records to be processed by the pool.
+	readerObjs := objs
+	if config.Workers > 1 {
+		raw := make(chan map[string]any, 16)
+		go processConcurrently(raw, objs, config)
+		readerObjs = raw
+	}
+
 	switch config.InputFormat {
 	case "json":
-		go readJSONInput(objs, inputTypeChan, config)
+		go readJSONInput(reader, readerObjs, inputTypeChan, config)
 	case "jsonl":
-		go readJSONLInput(objs, inputTypeChan, config)
+		go readJSONLInput(reader, readerObjs, inputTypeChan, config)
+	case "json-seq":
+		go readJSONSeqInput(reader, readerObjs, inputTypeChan, config)
 	case "yaml":
-		go readYAMLInput(objs, inputTypeChan, config)
-	case "csv":
-		go readCSVInput(objs, inputTypeChan, config)
+		go readYAMLInput(reader, readerObjs, inputTypeChan, config)
+	case "csv", "tsv":
+		go readCSVInput(reader, readerObjs, inputTypeChan, config)
+	case "fixed":
+		go readFixedInput(reader, readerObjs, inputTypeChan, config)
+	case "logfmt":
+		go readLogfmtInput(reader, readerObjs, inputTypeChan, config)
 	default:
-		log.Fatalf("Unsupported input format: %s", config.InputFormat)
+		fatalf("Unsupported input format: %s", config.InputFormat)
 	}
 
 	writer := bufio.NewWriter(os.Stdout)
+	var outFile *atomicFile
+	if config.Out != "" && config.Rotate == 0 {
+		outFile, err = createAtomicFile(config.Out)
+		if err != nil {
+			fatalf("Error opening output: %v", err)
+		}
+		defer outFile.abort()
+		defer onFatal(outFile.abort)()
+		writer = bufio.NewWriter(outFile)
+	}
 	defer writer.Flush()
+	// commitOutput moves a complete -out file into place.
+	commitOutput := func() {
+		if outFile == nil {
+			return
+		}
+		if err := writer.Flush(); err != nil {
+			fatalf("Error writing output: %v", err)
+		}
+		if err := outFile.commit(); err != nil {
+			fatalf("Error writing output: %v", err)
+		}
+	}
 
 	// Wait for the input type from the channel.
 	// If the channel is closed (e.g., empty input), it receives the zero value, which is SingletonInput.
 	inputType := <-inputTypeChan
 
-	formatter, err := NewFormatter(&config, writer, inputType) // Pass InputType to formatter
+	var rownums *numberer
+	if usesMappingKey(config, "rownum-by") || usesMappingKey(config, "running-sum") {
+		rownums = newNumberer()
+	}
+
+	if config.Explain {
+		writeExplanations(objs, writer, rownums)
+		stopProgress()
+		commitOutput()
+		return
+	}
+
+	sinks, err := openOutputSinks(&config, writer, inputType)
 	if err != nil {
-		log.Fatalf("Error creating formatter: %v", err)
+		fatalf("Error creating formatter: %v", err)
+	}
+
+	for _, sink := range sinks {
+		if err := sink.formatter.WriteHeader(); err != nil {
+			fatalf("Error writing header: %v", err)
+		}
 	}
 
-	if err := formatter.WriteHeader(); err != nil {
-		log.Fatalf("Error writing header: %v", err)
+	var dedupe *deduper
+	if config.Dedupe {
+		dedupe = newDeduper()
+	}
+
+	var sampler *reservoir
+	if config.Reservoir > 0 {
+		sampler = newReservoir(config.Reservoir, config.Seed)
+	}
+
+	emit := func(obj map[string]any) {
+		if rownums != nil {
+			rownums.number(obj)
+		}
+		if config.Flatten {
+			obj = flattenRecord(obj, config.FlattenDelim)
+		}
+		if !writeToSinks(sinks, obj) {
+			dropped++
+		}
 	}
 
+	// Records are held back for sampling and repeating, and emitted once the
+	// input ends.
+	var held []map[string]any
 	for obj := range objs {
-		if err := formatter.WriteRecord(obj); err != nil {
-			log.Printf("Error writing record: %v", err)
+		if dedupe != nil && dedupe.isDuplicate(obj) {
+			continue
 		}
+		if sampler != nil {
+			sampler.add(obj)
+			continue
+		}
+		if config.Repeat > 1 {
+			held = append(held, obj)
+			continue
+		}
+		emit(obj)
+	}
+	stopProgress()
+	if sampler != nil {
+		held = sampler.records
 	}
 
-	if err := formatter.WriteFooter(); err != nil {
-		log.Fatalf("Error writing footer: %v", err)
+	for range max(config.Repeat, 1) {
+		for _, obj := range held {
+			if rownums != nil && config.Repeat > 1 {
+				// Numbering replaces rownum-by and running-sum values in
+				// place, so each repetition numbers its own copy.
+				obj = cloneRecord(obj)
+			}
+			emit(obj)
+		}
 	}
+
+	for _, sink := range sinks {
+		if err := sink.formatter.WriteFooter(); err != nil {
+			fatalf("Error writing footer: %v", err)
+		}
+		if err := sink.close(); err != nil {
+			fatalf("Error closing output: %v", err)
+		}
+	}
+	commitOutput()
+}
+
+// fatalCleanups run before a fatal error exits the process, since os.Exit
+// skips deferred calls.
+var fatalCleanups struct {
+	sync.Mutex
+	funcs []func()
+}
+
+// onFatal registers fn to run if fatalf is called, and returns a function that
+// unregisters it.
+func onFatal(fn func()) func() {
+	fatalCleanups.Lock()
+	defer fatalCleanups.Unlock()
+	i := len(fatalCleanups.funcs)
+	fatalCleanups.funcs = append(fatalCleanups.funcs, fn)
+	return func() {
+		fatalCleanups.Lock()
+		defer fatalCleanups.Unlock()
+		fatalCleanups.funcs[i] = nil
+	}
+}
+
+// fatalf runs the registered cleanups, most recent first, then logs the
+// message and exits like log.Fatalf.
+func fatalf(format string, v ...any) {
+	fatalCleanups.Lock()
+	for i := len(fatalCleanups.funcs) - 1; i >= 0; i-- {
+		if fn := fatalCleanups.funcs[i]; fn != nil {
+			fn()
+		}
+	}
+	log.Fatalf(format, v...)
 }
 
 // Reads the command line flags and build a Config from the flags and an optional yaml config.
 func getConfig() Config {
 	version := "0.1.9"
 	var configPath string
+	var configDir string
+	var inlineConfig string
 	var config Config
 
 	flag.StringVar(&configPath, "c", "", "Path to configuration YAML file")
-	flag.StringVar(&config.InputFormat, "i", "yaml", "Input format: json, jsonl, yaml, or csv")
-	flag.StringVar(&config.OutputFormat, "o", "yaml", "Output format: json, jsonl, jsonp (pretty), yaml, or csv")
+	flag.StringVar(&config.ErrorsFile, "errors-file", "", "Write each skipped input record (a bad line, value, CSV row, or non-object) to this file, one per line")
+	flag.BoolVar(&config.ProgressBar, "progress-bar", false, "Show a progress bar on stderr of bytes read from the -f input file")
+	flag.BoolVar(&config.ConfigSection, "config-section", false, "Read YAML config from the start of the input, up to a '---TRMG---' line")
+	flag.BoolVar(&config.AllowInlineConfig, "allow-inline-config", false, "Apply a '#trmg: <yaml>' config directive on the first line of the input")
+	flag.StringVar(&inlineConfig, "e", "", "Inline configuration YAML, overriding keys from -c and -config-dir")
+	flag.StringVar(&configDir, "config-dir", "", "Directory of *.yaml config fragments, merged in lexical order after -c")
+	flag.StringVar(&config.InputFile, "f", "", "Read input from a file or a .zip archive instead of stdin")
+	flag.StringVar(&config.InputFormat, "i", "yaml", "Input format: json, jsonl, json-seq, yaml, csv, tsv, fixed, or logfmt")
+	flag.StringVar(&config.FixedCols, "fixed-cols", "", "Column ranges for fixed input, e.g. 'name:0-10,age:10-13'")
+	flag.Var((*outputTargets)(&config.Outputs), "o", "Output format[:path]: json, jsonl, json-seq, jsonp (pretty), yaml, toml, csv, tsv, xml, or template (repeatable)")
 	flag.BoolVar(&config.Buffered, "buffered", false, "Force buffered output (don't flush after each record)")
+	flag.BoolVar(&config.Repair, "repair", false, "Silently drop a truncated final JSONL line instead of reporting it")
+	flag.BoolVar(&config.Dedupe, "dedupe", false, "Drop output records identical to one already written")
+	flag.BoolVar(&config.Explain, "explain", false, "Instead of records, print which specific-outputs rule matched each input record")
+	flag.StringVar(&config.StripPrefixTo, "strip-prefix-to", "", "JSONL input: strip each line up to the first occurrence of this delimiter (escapes like \\t allowed)")
+	flag.StringVar(&config.PrefixField, "prefix-field", "", "JSONL input: store the text stripped by -strip-prefix-to in this field")
+	flag.StringVar(&config.JSONIndent, "json-indent", "2", "Indent for jsonp output: a number of spaces, \"tab\", or a literal string (escapes like \\t allowed)")
+	flag.IntVar(&config.MaxBuffer, "max-buffer", 0, "Fail if a buffering output (yaml for array input) would hold more than N records (0 = unlimited)")
+	flag.IntVar(&config.Workers, "workers", 1, "Process records on N goroutines, keeping the input order on output")
+	flag.IntVar(&config.Repeat, "repeat", 1, "Emit the records left after -dedupe and -reservoir N times, holding them all in memory (for generating test volume)")
+	flag.IntVar(&config.Reservoir, "reservoir", 0, "Output a uniform random sample of N records, chosen once the input ends")
+	flag.Int64Var(&config.Seed, "seed", 0, "Random seed for -reservoir (default: time-based)")
+	flag.StringVar(&config.CSVDelim, "csv-delim", "", "CSV/TSV input and output field delimiter, e.g. ';' or '|' (default: comma, or tab for tsv; escapes like \\t allowed)")
+	flag.BoolVar(&config.CSVNoHeader, "csv-no-header", false, "CSV/TSV output: omit the header row, e.g. when appending to an existing file")
+	flag.StringVar(&config.CSVQuote, "csv-quote", "minimal", "CSV output quoting: minimal or always")
+	flag.StringVar(&config.CSVEscape, "csv-escape-char", `"`, "CSV output character used to escape quotes inside quoted fields")
+	flag.StringVar(&config.TemplateFile, "tmpl", "", "File holding a Go text/template run on each record for template output")
+	flag.StringVar(&config.TemplateStr, "tmpl-str", "", "Go text/template run on each record for template output, if -tmpl is not given")
+	flag.StringVar(&config.TemplateHeader, "tmpl-header", "", "Go text/template written once before the records in template output")
+	flag.StringVar(&config.TemplateFooter, "tmpl-footer", "", "Go text/template written once after the records in template output")
+	flag.StringVar(&config.XMLRoot, "xml-root", "root", "Root element name for xml output")
+	flag.StringVar(&config.XMLItem, "xml-item", "item", "Element name for each record in xml output")
+	flag.BoolVar(&config.Transpose, "transpose", false, "CSV input: read a two-column key,value file as one object")
+	flag.BoolVar(&config.CSVCRLF, "csv-crlf", false, "End CSV output lines with \\r\\n instead of \\n")
+	flag.StringVar(&config.CSVHeaderComment, "csv-header-comment", "", "Comment line written before the CSV header, prefixed with #")
+	flag.BoolVar(&config.NewlineRobust, "input-newline-robust", false, "Treat \\r\\n, \\n, and a lone \\r all as line endings in line-oriented input")
+	flag.DurationVar(&config.StdinTimeout, "stdin-timeout", 0, "Exit with an error if no input arrives on stdin within this duration, e.g. 5s (default: wait forever)")
+	flag.BoolVar(&config.SortKeysRecursive, "sort-keys-recursive", false, "Order keys byte-wise at every nesting level in all output formats")
+	flag.StringVar(&config.Out, "out", "", "Write output to this file instead of stdout; with -rotate, the base name of the numbered files")
+	flag.StringVar(&config.OutTemplate, "out-template", "", "Write each record to its own file, named from the record, e.g. '{type}/{id}.json'")
+	flag.IntVar(&config.Rotate, "rotate", 0, "Split output into files of N records each, named <out>-0001.<format>, … (requires -out)")
+	flag.IntVar(&config.MaxLineBytes, "max-line-bytes", defaultMaxLineBytes, "Longest input line (jsonl, fixed) or json-seq record accepted, in bytes")
+	flag.BoolVar(&config.Flatten, "flatten", false, "Flatten nested objects in output records into keys joined by -flatten-delim")
+	flag.StringVar(&config.FlattenDelim, "flatten-delim", ".", "Delimiter joining nested keys for -flatten, e.g. __ for addr__city")
+	flag.BoolVar(&config.NoFallback, "no-fallback", false, "Never output an input record unchanged: records without mappings follow -on-empty instead")
+	flag.BoolVar(&config.KeepEmpty, "keep-empty", false, "Output {} instead of the original record when the mappings produce no fields (same as -on-empty emit)")
+	flag.StringVar(&config.OnEmpty, "on-empty", "emit", "When a record's mappings all produce nothing: emit {}, drop the record, or output the original")
+	flag.StringVar(&config.DupKeys, "dup-keys", "last", "JSON/JSONL input policy for duplicate object keys: error, warn, first, or last")
+	flag.StringVar(&config.JSONPath, "jsonpath", "", "JSONPath selecting the records in JSON input, e.g. '$.response.items[*]'")
+	schemaCmd := flag.Bool("config-schema", false, "Print a JSON Schema for the config file and exit")
 	versionCmd := flag.Bool("version", false, "Show version info")
 
 	flag.Usage = func() {
@@ -99,14 +343,34 @@ func getConfig() Config {
 		os.Exit(0)
 	}
 
-	if !contains([]string{"json", "jsonl", "yaml", "csv"}, config.InputFormat) {
-		stderrln("Invalid input format: " + config.InputFormat)
+	if *schemaCmd {
+		schema, err := json.MarshalIndent(configSchema(), "", "  ")
+		if err != nil {
+			fatalf("Error generating config schema: %v", err)
+		}
+		fmt.Println(string(schema))
 		os.Exit(0)
 	}
-	if !contains([]string{"json", "jsonl", "jsonp", "yaml", "csv"}, config.OutputFormat) {
-		stderrln("Invalid output format: " + config.OutputFormat)
+
+	seedSet := false
+	flag.Visit(func(f *flag.Flag) { seedSet = seedSet || f.Name == "seed" })
+	if !seedSet {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	config.StripPrefixTo = unescape(config.StripPrefixTo)
+	indent, err := parseIndent(config.JSONIndent)
+	if err != nil {
+		stderrln("Invalid -json-indent: " + err.Error())
 		os.Exit(0)
 	}
+	config.JSONIndent = indent
+	config.CSVDelim = unescape(config.CSVDelim)
+
+	if len(config.Outputs) == 0 {
+		config.Outputs = []OutputTarget{{Format: DEFAULT_OUTPUT_FORMAT}}
+	}
+	config.OutputFormat = config.Outputs[0].Format
 
 	if configPath != "" {
 		// Read and parse the configuration. The path is intentionally supplied
@@ -114,41 +378,182 @@ func getConfig() Config {
 		// #nosec G304
 		configData, err := os.ReadFile(configPath)
 		if err != nil {
-			log.Fatalf("Error reading config file: %v", err)
+			fatalf("Error reading config file: %v", err)
 		}
-		if err := yaml.Unmarshal(configData, &config); err != nil {
-			log.Fatalf("Error parsing config file: %v", err)
+		if err := unmarshalConfig(configPath, configData, &config); err != nil {
+			fatalf("Error parsing config file: %v", err)
+		}
+	}
+	if configDir != "" {
+		if err := loadConfigDir(&config, configDir); err != nil {
+			fatalf("Error reading config directory: %v", err)
+		}
+	}
+	if inlineConfig != "" {
+		// Keys given inline replace those from the config files.
+		if err := yaml.Unmarshal([]byte(inlineConfig), &config); err != nil {
+			fatalf("Error parsing -e config: %v", err)
 		}
 	}
 	if config.MatchRule == "" {
 		config.MatchRule = "all"
 	}
+	if err := validateConfig(&config); err != nil {
+		stderrln(err.Error())
+		os.Exit(0)
+	}
 	return config
 }
 
+// validateConfig reports the first invalid setting or combination of settings
+// in config, and decodes its mapping definitions with compileMappings. It runs
+// once the flags and config files are loaded, and again after config read from
+// the input is merged in.
+func validateConfig(config *Config) error {
+	if !contains([]string{"json", "jsonl", "json-seq", "yaml", "csv", "tsv", "fixed", "logfmt"}, config.InputFormat) {
+		return errors.New("Invalid input format: " + config.InputFormat)
+	}
+	if !contains([]string{"all", "drop-no-match"}, config.MatchRule) {
+		return errors.New("Invalid match-rule: " + config.MatchRule)
+	}
+	for i := range config.SpecificOutputs {
+		rule := &config.SpecificOutputs[i]
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("Invalid specific-outputs rule %s: %v", rule.Label(i), err)
+		}
+	}
+	if config.CSVDelim != "" && (utf8.RuneCountInString(config.CSVDelim) != 1 || strings.ContainsAny(config.CSVDelim, "\"\r\n")) {
+		return errors.New("Invalid CSV delimiter: " + config.CSVDelim)
+	}
+	if !contains([]string{"minimal", "always"}, config.CSVQuote) {
+		return errors.New("Invalid CSV quote mode: " + config.CSVQuote)
+	}
+	if utf8.RuneCountInString(config.CSVEscape) != 1 {
+		return errors.New("Invalid CSV escape character: " + config.CSVEscape)
+	}
+
+	if !contains([]string{"error", "warn", "first", "last"}, config.DupKeys) {
+		return errors.New("Invalid duplicate key policy: " + config.DupKeys)
+	}
+
+	if !contains([]string{"emit", "drop", "original"}, config.OnEmpty) {
+		return errors.New("Invalid -on-empty policy: " + config.OnEmpty)
+	}
+	if config.KeepEmpty && config.OnEmpty != "emit" {
+		return errors.New("-keep-empty cannot be combined with -on-empty " + config.OnEmpty)
+	}
+	if config.NoFallback && config.OnEmpty == "original" {
+		return errors.New("-no-fallback cannot be combined with -on-empty original")
+	}
+
+	if config.Workers < 1 {
+		return errors.New("-workers must be at least 1")
+	}
+	if config.Repeat < 1 {
+		return errors.New("-repeat must be at least 1")
+	}
+
+	if config.Rotate < 0 || (config.Rotate > 0 && config.Out == "") {
+		return errors.New("-rotate requires a positive record count and -out <base>")
+	}
+
+	for _, target := range config.Outputs {
+		if !contains([]string{"json", "jsonl", "json-seq", "jsonp", "yaml", "toml", "csv", "tsv", "xml", "template"}, target.Format) {
+			return errors.New("Invalid output format: " + target.Format)
+		}
+	}
+	if err := compileMappings(config); err != nil {
+		return fmt.Errorf("Invalid mapping in %v", err)
+	}
+	return nil
+}
+
+// unmarshalConfig decodes a config file into config. JSON is a subset of YAML,
+// so both go through the YAML decoder and build the same mappings; a .json
+// file is checked with the JSON parser first so that errors read as JSON
+// errors and non-JSON syntax is rejected.
+func unmarshalConfig(path string, data []byte, config *Config) error {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var doc any
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+	}
+	return yaml.Unmarshal(data, config)
+}
+
+// loadConfigDir merges every *.yaml file in dir into config, in lexical
+// order. An empty directory leaves config unchanged.
+func loadConfigDir(config *Config, dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		// The directory is intentionally supplied by the CLI user.
+		// #nosec G304
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var fragment Config
+		if err := yaml.Unmarshal(data, &fragment); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		config.merge(fragment)
+	}
+	return nil
+}
+
 // getValueByPath traverses a record (a map) following a dot-separated path.
 func getValueByPath(record map[string]any, path string) any {
 	val, _ := lookupValueByPath(record, path)
 	return val
 }
 
-// lookupValueByPath traverses a record following a dot-separated path and
-// reports whether that path exists, even if the resulting value is nil.
+// lookupValueByPath traverses a record following a dot-separated path, where
+// integer segments index into arrays, and reports whether that path exists,
+// even if the resulting value is nil. A "*" segment over an array collects the
+// rest of the path from every element into a new array.
 func lookupValueByPath(record map[string]any, path string) (any, bool) {
 	if path == "" {
 		return nil, false
 	}
+	return lookupValue(record, strings.Split(path, "."))
+}
 
-	parts := strings.Split(path, ".")
-	var current any = record
-	for _, part := range parts {
-		if m, ok := current.(map[string]any); ok {
-			val, exists := m[part]
+func lookupValue(current any, parts []string) (any, bool) {
+	for i, part := range parts {
+		switch node := current.(type) {
+		case map[string]any:
+			val, exists := node[part]
 			if !exists {
 				return nil, false
 			}
 			current = val
-		} else {
+		case []any:
+			if part == "*" {
+				// Elements missing the rest of the path are skipped.
+				vals := []any{}
+				for _, elem := range node {
+					if val, ok := lookupValue(elem, parts[i+1:]); ok {
+						vals = append(vals, val)
+					}
+				}
+				return vals, true
+			}
+			// An integer segment indexes into an array.
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || n >= len(node) {
+				return nil, false
+			}
+			current = node[n]
+		default:
+			// Includes a "*" over anything but an array.
 			return nil, false
 		}
 	}
@@ -181,31 +586,12 @@ func applyMapping(name string, in, out map[string]any, outSpec any) {
 			out[name] = v
 		}
 	case OutputMap:
-		if hasKeys(v, "src", "regex", "value") {
-			src := strval(v, "src")
-			regex := strval(v, "regex")
-			re, err := regexp.Compile(regex)
+		if isMappingDefinition(v) {
+			md, err := newMappingDefinition(v)
 			if err != nil {
 				return
 			}
-			srcVal, ok := getValueByPath(in, src).(string)
-			if !ok {
-				return
-			}
-			matches := re.FindStringSubmatch(srcVal)
-			if len(matches) == 0 {
-				return
-			}
-			val := strval(v, "value")
-			if val != "" {
-				result := val
-				// Replace $1, $2, … with captured groups.
-				for i, match := range matches[1:] {
-					placeholder := fmt.Sprintf("$%d", i+1)
-					result = strings.ReplaceAll(result, placeholder, match)
-				}
-				out[name] = result
-			}
+			applyDefinition(name, in, out, md)
 		} else {
 			newout := make(OutputMap)
 			out[name] = newout
@@ -213,23 +599,114 @@ func applyMapping(name string, in, out map[string]any, outSpec any) {
 				applyMapping(k, in, newout, v[k])
 			}
 		}
+	case map[string]any:
+		// Maps decoded without the OutputMap type, e.g. from JSON, are the
+		// same mappings.
+		applyMapping(name, in, out, OutputMap(v))
+	case *MappingDefinition:
+		applyDefinition(name, in, out, v)
+	}
+}
+
+// applyDefinition writes the value of a mapping definition under name.
+func applyDefinition(name string, in, out map[string]any, md *MappingDefinition) {
+	if md.KeyRegex != "" {
+		// Selected keys are written under their own names, not under name.
+		md.copyMatchingKeys(in, out)
+	} else if val, ok := md.resolve(in); ok {
+		out[name] = val
 	}
 }
 
 // applyFieldMappings applies a list of field mappings to an output record based on an input record.
-func applyFieldMappings(record map[string]any, output map[string]any, mappings []FieldMapping) {
+// Any rule captures are substituted for $1, $2, … in the mappings first.
+func applyFieldMappings(record map[string]any, output map[string]any, mappings []FieldMapping, captures []string) {
 	for _, fm := range mappings {
-		applyMapping(fm.Key, record, output, fm.Output)
+		outSpec := fm.Output
+		if captures != nil {
+			outSpec = expandSpecCaptures(outSpec, captures)
+		}
+		applyMapping(fm.Key, record, output, outSpec)
+	}
+}
+
+// expandSpecCaptures returns a copy of a mapping spec with the captures
+// substituted into every string. A mapping with its own regex keeps its value
+// and lookup-path templates, which refer to that regex's groups instead.
+func expandSpecCaptures(outSpec any, captures []string) any {
+	switch v := outSpec.(type) {
+	case string:
+		return expandCaptures(v, captures)
+	case OutputMap:
+		ownRegex := isMappingDefinition(v) && hasKeys(v, "regex")
+		expanded := make(OutputMap, len(v))
+		for k, child := range v {
+			if ownRegex && (k == "value" || k == "lookup-path") {
+				expanded[k] = child
+				continue
+			}
+			expanded[k] = expandSpecCaptures(child, captures)
+		}
+		return expanded
+	case map[string]any:
+		return expandSpecCaptures(OutputMap(v), captures)
+	case *MappingDefinition:
+		// Expanded from the OutputMap it was decoded from, and decoded again.
+		if v.spec == nil {
+			return v
+		}
+		return expandSpecCaptures(v.spec, captures)
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = expandSpecCaptures(item, captures)
+		}
+		return items
 	}
+	return outSpec
 }
 
 // processInput processes one record:
 // 1. Clones the original if configured.
 // 2. Applies the common mappings.
-// 3. Iterates over specific rules (first match wins) and merges in its extra mappings.
+// 3. Iterates over specific rules (first match wins) and merges in its extra mappings,
+// deep-merging nested maps when the rule sets "merge: deep",
+// or returns a raw output line if the rule has an output-raw template.
 // 4. If no specific rule matches and matchRule is "drop-no-match", returns nil.
 // 5. If no specific rule matches and matchRule is "all", returns original record.
+// 6. If no mappings apply, returns the original record. If mappings apply but
+// produce no fields, returns the empty record, or per -on-empty nil or the original.
+// 7. Keeps only the keep-keys, or else removes the drop-keys, from the result.
 func processInput(record map[string]any, config Config) map[string]any {
+	output := mapRecord(record, config)
+	if _, raw := rawLine(output); raw || output == nil {
+		return output
+	}
+	return selectKeys(output, config)
+}
+
+// selectKeys limits a mapped record to the top-level keep-keys, or else
+// removes the drop-keys. The record is copied so that a record passed
+// through whole is never modified.
+func selectKeys(record map[string]any, config Config) map[string]any {
+	if len(config.KeepKeys) == 0 && len(config.DropKeys) == 0 {
+		return record
+	}
+	selected := make(map[string]any, len(record))
+	for k, v := range record {
+		if len(config.KeepKeys) > 0 {
+			if slices.Contains(config.KeepKeys, k) {
+				selected[k] = v
+			}
+		} else if !slices.Contains(config.DropKeys, k) {
+			selected[k] = v
+		}
+	}
+	return selected
+}
+
+// mapRecord carries out steps 1-6 of processInput.
+func mapRecord(record map[string]any, config Config) map[string]any {
 	var output map[string]any
 	if config.CloneOriginal {
 		output = make(map[string]any, len(record))
@@ -239,101 +716,323 @@ func processInput(record map[string]any, config Config) map[string]any {
 	}
 
 	commonMappings := convertFieldMappings(config.CommonOutput)
-	applyFieldMappings(record, output, commonMappings)
-	matchedSpecific := false
-	for _, rule := range config.SpecificOutputs {
-		if rule.Check(record) {
-			matchedSpecific = true
-			ruleMappings := convertFieldMappings(rule.Output)
-			applyFieldMappings(record, output, ruleMappings)
-			break
+	applyFieldMappings(record, output, commonMappings, nil)
+	hasMappings := len(commonMappings) > 0
+	ruleIndex := matchSpecificRule(record, config)
+	matchedSpecific := ruleIndex >= 0
+	if matchedSpecific {
+		rule := config.SpecificOutputs[ruleIndex]
+		if rule.OutputRaw != "" {
+			return map[string]any{rawOutputKey: renderPlaceholders(rule.OutputRaw, record)}
+		}
+		ruleMappings := convertFieldMappings(rule.Output)
+		hasMappings = hasMappings || len(ruleMappings) > 0
+		captures := rule.Captures(record)
+		if rule.Merge == "deep" {
+			ruleOutput := make(map[string]any)
+			applyFieldMappings(record, ruleOutput, ruleMappings, captures)
+			deepMerge(output, ruleOutput)
+		} else {
+			applyFieldMappings(record, output, ruleMappings, captures)
 		}
 	}
 	if config.MatchRule == "drop-no-match" && !matchedSpecific {
 		return nil
 	}
 
-	// Nothing was mapped and we didn't clone the original, so we output the whole thing
 	if !config.CloneOriginal && len(output) == 0 {
-		return record
+		// No mappings apply to this record, so we output the whole thing,
+		// unless -no-fallback forbids passing records through.
+		if !hasMappings && !config.NoFallback {
+			return record
+		}
+		// Mappings apply but all of them missed.
+		if config.KeepEmpty {
+			return output
+		}
+		switch config.OnEmpty {
+		case "drop":
+			return nil
+		case "original":
+			return record
+		}
 	}
 
 	return output
 }
 
-func readJSONInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+// rawOutputKey marks a record that carries a pre-rendered line to be written
+// verbatim instead of being formatted.
+const rawOutputKey = "\x00raw"
+
+// rawLine returns the pre-rendered line carried by a record, if any.
+func rawLine(record map[string]any) (string, bool) {
+	line, ok := record[rawOutputKey].(string)
+	return line, ok
+}
+
+var placeholderRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// renderPlaceholders replaces each {path} in the template with the value at
+// that path in the record. Missing values render as an empty string.
+func renderPlaceholders(template string, record map[string]any) string {
+	return placeholderRegex.ReplaceAllStringFunc(template, func(m string) string {
+		val := getValueByPath(record, m[1:len(m)-1])
+		if val == nil {
+			return ""
+		}
+		return fmt.Sprint(val)
+	})
+}
+
+// matchSpecificRule returns the index of the first specific rule that matches
+// the record, or -1 if none does.
+func matchSpecificRule(record map[string]any, config Config) int {
+	for i, rule := range config.SpecificOutputs {
+		if rule.Check(record) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sendProcessed processes a record and sends the result, if any, to objs.
+// With -workers the record is sent as is, for the worker pool to process.
+func sendProcessed(record map[string]any, objs chan<- map[string]any, config Config) {
+	if config.Workers > 1 {
+		objs <- record
+		return
+	}
+	if result := processRecord(record, config); result != nil {
+		objs <- result
+	}
+}
+
+// processRecord processes a record. In explain mode it returns a description
+// of the matched rule instead, so that every input record is accounted for.
+func processRecord(record map[string]any, config Config) map[string]any {
+	if config.Explain {
+		return map[string]any{"rule": explainMatch(record, config)}
+	}
+	return processInput(record, config)
+}
+
+// explainMatch describes which specific rule matches the record.
+func explainMatch(record map[string]any, config Config) string {
+	i := matchSpecificRule(record, config)
+	if i < 0 {
+		return "no match"
+	}
+	return "rule " + config.SpecificOutputs[i].Label(i)
+}
+
+// writeExplanations writes one "index: rule" line per explained record.
+// Placeholders left by rownum-by and running-sum are resolved by rownums, if
+// given, so that they never appear in the output.
+func writeExplanations(objs <-chan map[string]any, writer io.Writer, rownums *numberer) {
+	i := 0
+	for obj := range objs {
+		if rownums != nil {
+			rownums.number(obj)
+		}
+		fmt.Fprintf(writer, "%d: %v\n", i, obj["rule"])
+		i++
+	}
+}
+
+// deepMerge merges src into dst, recursing into nested maps present in both
+// instead of overwriting them. Nested maps in dst are copied before being
+// merged into so the input record is never modified.
+func deepMerge(dst, src map[string]any) {
+	for k, v := range src {
+		srcMap, srcOK := asStringMap(v)
+		dstMap, dstOK := asStringMap(dst[k])
+		if srcOK && dstOK {
+			merged := make(OutputMap, len(dstMap)+len(srcMap))
+			maps.Copy(merged, dstMap)
+			deepMerge(merged, srcMap)
+			dst[k] = merged
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// asStringMap returns v as a map if it is either a decoded map or an OutputMap.
+func asStringMap(v any) (map[string]any, bool) {
+	switch m := v.(type) {
+	case map[string]any:
+		return m, true
+	case OutputMap:
+		return m, true
+	}
+	return nil, false
+}
+
+func readJSONInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
 	defer close(objs)
 	defer close(inputTypeChan)
 
-	input, err := io.ReadAll(os.Stdin)
+	data, err := io.ReadAll(input)
 	if err != nil {
-		log.Fatalf("Error reading input: %v", err)
+		fatalf("Error reading input: %v", err)
+	}
+	if len(data) == 0 {
+		return
 	}
-	if len(input) == 0 {
+
+	if config.JSONPath != "" {
+		readJSONPathRecords(data, objs, inputTypeChan, config)
 		return
 	}
 
-	// Try to unmarshal into an array of objects first.
-	var records []map[string]any
-	errArray := json.Unmarshal(input, &records)
-	if errArray == nil {
+	// An array of objects is a sequence of records; anything else must be a single object.
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var records []map[string]any
+		if err := unmarshalJSON(data, &records, config.DupKeys); err != nil {
+			fatalf("Error parsing JSON input: %v", err)
+		}
 		inputTypeChan <- ArrayInput // It's an array
 		for _, record := range records {
-			result := processInput(record, config)
-			if result != nil {
-				objs <- result
-			}
+			sendProcessed(record, objs, config)
 		}
 		return
 	}
 
-	// If unmarshaling into an array fails, try a single object.
-	var record map[string]any
-	errObject := json.Unmarshal(input, &record)
-	if errObject == nil {
-		inputTypeChan <- SingletonInput // It's a single object
-		result := processInput(record, config)
-		if result != nil {
-			objs <- result
+	values, err := splitJSONValues(data)
+	if err != nil {
+		fatalf("Error parsing JSON input: %v", err)
+	}
+	if len(values) == 1 {
+		var record map[string]any
+		if err := unmarshalJSON(data, &record, config.DupKeys); err != nil {
+			fatalf("Error parsing JSON input: %v", err)
 		}
+		inputTypeChan <- SingletonInput // It's a single object
+		sendProcessed(record, objs, config)
 		return
 	}
 
-	// If both fail, report the most likely error.
-	log.Fatalf("Error parsing JSON input: %v", errArray)
+	// Back-to-back values such as {...}{...} are a stream of records.
+	inputTypeChan <- StreamInput
+	for _, value := range values {
+		var record map[string]any
+		if err := unmarshalJSON(value, &record, config.DupKeys); err != nil {
+			log.Printf("Error parsing JSON: %v", err)
+			config.rejects.write(value)
+			continue
+		}
+		sendProcessed(record, objs, config)
+	}
+}
+
+// readJSONPathRecords sends each object selected by -jsonpath as a record.
+// Selected values that are not objects are logged and skipped, and written to
+// -errors-file as JSON.
+func readJSONPathRecords(data []byte, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+	steps, err := parseJSONPath(config.JSONPath)
+	if err != nil {
+		fatalf("Error parsing -jsonpath: %v", err)
+	}
+	var doc any
+	if err := unmarshalJSON(data, &doc, config.DupKeys); err != nil {
+		fatalf("Error parsing JSON input: %v", err)
+	}
+	inputTypeChan <- ArrayInput // A path selects a sequence of records
+	for _, v := range selectJSONPath(doc, steps) {
+		record, ok := v.(map[string]any)
+		if !ok {
+			log.Printf("Skipping non-object value selected by -jsonpath: %v", v)
+			if value, err := json.Marshal(v); err == nil {
+				config.rejects.write(value)
+			}
+			continue
+		}
+		sendProcessed(record, objs, config)
+	}
 }
 
-func readJSONLInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+func readJSONLInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
 	defer close(objs)
 	defer close(inputTypeChan)
 
 	inputTypeChan <- StreamInput
 
-	scanner := bufio.NewScanner(os.Stdin)
+	// A parse error is held until the next line arrives so that, in repair
+	// mode, a truncated final line can be told apart from a bad middle line.
+	var pendingErr error
+	var pendingLine string
+	scanner := newLineScanner(input, config)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
+		if pendingErr != nil {
+			log.Printf("Error parsing JSON: %v", pendingErr)
+			config.rejects.write([]byte(pendingLine))
+			pendingErr = nil
+		}
+		pendingLine = line
+		var prefix string
+		var hasPrefix bool
+		if config.StripPrefixTo != "" {
+			if before, after, found := strings.Cut(line, config.StripPrefixTo); found {
+				prefix, line, hasPrefix = before, after, true
+			}
+		}
 		var record map[string]any
-		if err := json.Unmarshal([]byte(line), &record); err != nil {
-			log.Printf("Error parsing JSON: %v", err)
+		if err := unmarshalJSON([]byte(line), &record, config.DupKeys); err != nil {
+			pendingErr = err
+			continue
+		}
+		if hasPrefix && config.PrefixField != "" && record != nil {
+			record[config.PrefixField] = prefix
+		}
+		sendProcessed(record, objs, config)
+	}
+	if pendingErr != nil && !config.Repair {
+		log.Printf("Error parsing JSON: %v", pendingErr)
+		config.rejects.write([]byte(pendingLine))
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf("Error reading JSONL input: %v", err)
+	}
+}
+
+// readJSONSeqInput reads an RFC 7464 JSON text sequence: JSON values each
+// preceded by a record separator (0x1E). Values that fail to parse, such as a
+// truncated one, are logged and skipped.
+func readJSONSeqInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+	defer close(objs)
+	defer close(inputTypeChan)
+
+	inputTypeChan <- StreamInput
+
+	scanner := newScanner(input, config)
+	scanner.Split(scanJSONSeq)
+	for scanner.Scan() {
+		value := bytes.TrimSpace(scanner.Bytes())
+		if len(value) == 0 {
 			continue
 		}
-		result := processInput(record, config)
-		if result != nil {
-			objs <- result
+		var record map[string]any
+		if err := unmarshalJSON(value, &record, config.DupKeys); err != nil {
+			log.Printf("Error parsing JSON: %v", err)
+			config.rejects.write(value)
+			continue
 		}
+		sendProcessed(record, objs, config)
 	}
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading JSONL input: %v", err)
+		fatalf("Error reading JSON text sequence input: %v", err)
 	}
 }
 
-func readYAMLInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+func readYAMLInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
 	defer close(objs)
 	defer close(inputTypeChan)
-	decoder := yaml.NewDecoder(os.Stdin)
+	decoder := yaml.NewDecoder(input)
 
 	var firstObj any
 	err := decoder.Decode(&firstObj)
@@ -341,7 +1040,7 @@ func readYAMLInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, c
 		if err == io.EOF { // Handle empty input
 			return
 		}
-		log.Fatalf("Error decoding first YAML object: %v", err)
+		fatalf("Error decoding first YAML object: %v", err)
 	}
 
 	var secondObj any
@@ -390,42 +1089,62 @@ func readYAMLInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, c
 	}
 }
 
-// processDecodedYAML is a helper to avoid repetition in readYAMLInput
+// processDecodedYAML is a helper to avoid repetition in readYAMLInput.
+// Documents and array items that are not maps are skipped, and written to
+// -errors-file as single-line YAML.
 func processDecodedYAML(doc any, objs chan<- map[string]any, config Config) {
 	if rec, ok := doc.(map[string]any); ok {
-		result := processInput(rec, config)
-		if result != nil {
-			objs <- result
-		}
+		sendProcessed(rec, objs, config)
 	} else {
 		log.Printf("Skipping YAML document in stream; not a map[string]any: %T", doc)
+		if value, err := flowYAML(doc); err == nil {
+			config.rejects.write(value)
+		}
+	}
+}
+
+// flowYAML encodes v as YAML in flow style, on a single line.
+func flowYAML(v any) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return nil, err
 	}
+	node.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&node)
+	return bytes.TrimSuffix(out, []byte("\n")), err
 }
 
-func readCSVInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+func readCSVInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
 	defer close(objs)
 	defer close(inputTypeChan)
-	inputTypeChan <- ArrayInput // CSV is always treated as an array
-
-	reader := csv.NewReader(os.Stdin)
+	reader := newCSVRowReader(input)
+	reader.Comma = csvComma(config.InputFormat, config.CSVDelim)
 
 	// Read header row
-	headers, err := reader.Read()
+	headers, _, err := reader.readRow()
 	if err != nil {
 		if err == io.EOF { // Handle empty file
 			return
 		}
-		log.Fatalf("Error reading CSV header: %v", err)
+		fatalf("Error reading CSV header: %v", err)
 	}
 
+	if config.Transpose {
+		inputTypeChan <- SingletonInput
+		sendProcessed(readTransposedCSV(reader, headers, config), objs, config)
+		return
+	}
+	inputTypeChan <- ArrayInput // CSV is otherwise always treated as an array
+
 	// Read data rows
 	for {
-		record, err := reader.Read()
+		record, raw, err := reader.readRow()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			log.Printf("Error reading CSV record: %v", err)
+			config.rejects.write(raw)
 			continue
 		}
 
@@ -433,15 +1152,204 @@ func readCSVInput(objs chan<- map[string]any, inputTypeChan chan<- InputType, co
 		obj := make(map[string]any, len(headers))
 		for i, value := range record {
 			if i < len(headers) {
-				obj[headers[i]] = value
+				obj[headers[i]] = csvValue(value, config.CSVInferTypes)
 			}
 		}
 
-		processed := processInput(obj, config)
-		if processed != nil {
-			objs <- processed
+		sendProcessed(obj, objs, config)
+	}
+}
+
+// csvValue returns a CSV field as a string or, with csv-infer-types, as the
+// int, float64, or bool it spells. Numbers with leading zeros, such as zip
+// codes, and values like "NaN" stay strings.
+func csvValue(value string, inferTypes bool) any {
+	if !inferTypes {
+		return value
+	}
+	switch value {
+	case "true", "TRUE", "True":
+		return true
+	case "false", "FALSE", "False":
+		return false
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return value
+	}
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	return value
+}
+
+// csvRowReader is a csv.Reader that also returns the input text of each row,
+// so that rows that fail to parse can be written to -errors-file verbatim.
+type csvRowReader struct {
+	*csv.Reader
+	input *rowRecorder
+}
+
+func newCSVRowReader(input io.Reader) *csvRowReader {
+	recorder := &rowRecorder{reader: input}
+	return &csvRowReader{Reader: csv.NewReader(recorder), input: recorder}
+}
+
+// readRow reads one record and returns it with the text it was read from,
+// without the line ending.
+func (r *csvRowReader) readRow() ([]string, []byte, error) {
+	record, err := r.Read()
+	raw := r.input.take(r.InputOffset())
+	return record, bytes.TrimRight(raw, "\r\n"), err
+}
+
+// rowRecorder keeps the bytes read through it that have not been taken yet.
+type rowRecorder struct {
+	reader io.Reader
+	buf    []byte
+	offset int64 // input offset of buf[0]
+}
+
+func (r *rowRecorder) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// take returns the recorded bytes up to the input offset end, and forgets them.
+func (r *rowRecorder) take(end int64) []byte {
+	n := min(int(end-r.offset), len(r.buf))
+	raw := slices.Clone(r.buf[:n])
+	r.buf = append(r.buf[:0], r.buf[n:]...)
+	r.offset += int64(n)
+	return raw
+}
+
+// readTransposedCSV builds one object from a two-column key/value CSV whose
+// header row has already been read. A repeated key keeps its last value.
+func readTransposedCSV(reader *csvRowReader, headers []string, config Config) map[string]any {
+	if len(headers) != 2 {
+		fatalf("-transpose requires a two-column CSV, got %d columns", len(headers))
+	}
+	obj := make(map[string]any)
+	for {
+		row, raw, err := reader.readRow()
+		if err == io.EOF {
+			return obj
+		}
+		if err != nil {
+			log.Printf("Error reading CSV record: %v", err)
+			config.rejects.write(raw)
+			continue
 		}
+		if _, dup := obj[row[0]]; dup {
+			log.Printf("Duplicate key %q in transposed CSV; keeping the last value", row[0])
+		}
+		obj[row[0]] = csvValue(row[1], config.CSVInferTypes)
+	}
+}
+
+// csvComma returns the field delimiter for a CSV-family format: the
+// -csv-delim character if set, otherwise a tab for tsv and a comma.
+func csvComma(format, delim string) rune {
+	if delim != "" {
+		return []rune(delim)[0]
+	}
+	if format == "tsv" {
+		return '\t'
+	}
+	return ','
+}
+
+// fixedColumn is a named, half-open range of character positions in a fixed-width line.
+type fixedColumn struct {
+	name       string
+	start, end int
+}
+
+// parseFixedColumns parses a spec like "name:0-10,age:10-13".
+func parseFixedColumns(spec string) ([]fixedColumn, error) {
+	var cols []fixedColumn
+	for _, part := range strings.Split(spec, ",") {
+		name, rng, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid fixed column %q", part)
+		}
+		startStr, endStr, ok := strings.Cut(rng, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range for fixed column %q", name)
+		}
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start for fixed column %q: %v", name, err)
+		}
+		end, err := strconv.Atoi(endStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end for fixed column %q: %v", name, err)
+		}
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("invalid range for fixed column %q", name)
+		}
+		cols = append(cols, fixedColumn{name: name, start: start, end: end})
+	}
+	return cols, nil
+}
+
+func readFixedInput(input io.Reader, objs chan<- map[string]any, inputTypeChan chan<- InputType, config Config) {
+	defer close(objs)
+	defer close(inputTypeChan)
+
+	cols, err := parseFixedColumns(config.FixedCols)
+	if err != nil {
+		fatalf("Error parsing fixed columns: %v", err)
+	}
+
+	inputTypeChan <- StreamInput
+
+	scanner := newLineScanner(input, config)
+	for scanner.Scan() {
+		line := []rune(scanner.Text())
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+		record := make(map[string]any, len(cols))
+		for _, col := range cols {
+			start := min(col.start, len(line))
+			end := min(col.end, len(line))
+			record[col.name] = strings.TrimSpace(string(line[start:end]))
+		}
+		sendProcessed(record, objs, config)
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf("Error reading fixed-width input: %v", err)
+	}
+}
+
+// unescape interprets Go escape sequences such as \t in a flag value, returning
+// the value unchanged if it is not a valid escaped string.
+func unescape(s string) string {
+	if u, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return u
+	}
+	return s
+}
+
+// parseIndent turns an indent flag value into the indent string: a number of
+// spaces, "tab", or a literal string with escapes interpreted.
+func parseIndent(s string) (string, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return "", fmt.Errorf("negative indent %d", n)
+		}
+		return strings.Repeat(" ", n), nil
+	}
+	if s == "tab" {
+		return "\t", nil
 	}
+	return unescape(s), nil
 }
 
 func stderrln(s string) {
@@ -3,9 +3,12 @@ package main
 import (
 	"bytes"
 	"flag"
+	"fmt"
 	"io"
+	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -109,6 +112,237 @@ specific-outputs:
 	})
 }
 
+func Test_processInput_deepMerge(t *testing.T) {
+	const config = `
+common-output:
+- meta:
+    source: src
+    region: region
+specific-outputs:
+- field: kind
+  eq: audit
+  merge: %s
+  output:
+  - meta:
+      region: rule-region
+      actor: user
+`
+	record := map[string]any{"kind": "audit", "src": "gke", "region": "us", "user": "alice"}
+
+	t.Run("deep merge keeps common nested fields", func(t *testing.T) {
+		cfg := mustConfig(t, fmt.Sprintf(config, "deep"))
+		got := processInput(record, *cfg)
+		want := map[string]any{
+			"meta": OutputMap{"source": "gke", "region": "rule-region", "actor": "alice"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("default merge replaces nested map", func(t *testing.T) {
+		cfg := mustConfig(t, fmt.Sprintf(config, "shallow"))
+		got := processInput(record, *cfg)
+		want := map[string]any{
+			"meta": OutputMap{"region": "rule-region", "actor": "alice"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("deep merge does not modify cloned input", func(t *testing.T) {
+		in := map[string]any{"kind": "audit", "user": "alice", "meta": map[string]any{"source": "gke"}}
+		cfg := mustConfig(t, `
+clone-original: true
+specific-outputs:
+- field: kind
+  eq: audit
+  merge: deep
+  output:
+  - meta:
+      actor: user
+`)
+		got := processInput(in, *cfg)
+		want := OutputMap{"source": "gke", "actor": "alice"}
+		if !reflect.DeepEqual(got["meta"], want) {
+			t.Errorf("got %v, want %v", got["meta"], want)
+		}
+		if _, modified := in["meta"].(map[string]any)["actor"]; modified {
+			t.Errorf("input record was modified: %v", in["meta"])
+		}
+	})
+}
+
+func Test_explain(t *testing.T) {
+	cfg := mustConfig(t, `
+match-rule: drop-no-match
+specific-outputs:
+- field: type
+  eq: audit
+  output:
+  - kind: type
+- name: syslog
+  field: type
+  matches: ^sys
+  output:
+  - kind: type
+`)
+	cfg.Explain = true
+	records := []map[string]any{
+		{"type": "syslog"},
+		{"type": "audit"},
+		{"type": "metric"},
+	}
+
+	objs := make(chan map[string]any, len(records))
+	for _, record := range records {
+		sendProcessed(record, objs, *cfg)
+	}
+	close(objs)
+
+	var buf bytes.Buffer
+	writeExplanations(objs, &buf, nil)
+
+	// The first rule is unnamed and falls back to its index.
+	want := "0: rule syslog\n1: rule 0\n2: no match\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_processInput_outputRaw(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+- id: id
+specific-outputs:
+- field: level
+  eq: debug
+  output-raw: "DEBUG {id}: {msg.text}{missing}"
+  output: []
+`)
+
+	got := processInput(map[string]any{"id": "7", "level": "debug", "msg": map[string]any{"text": "hi"}}, *cfg)
+	line, ok := rawLine(got)
+	if !ok {
+		t.Fatalf("expected a raw line, got %v", got)
+	}
+	if want := "DEBUG 7: hi"; line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+
+	got = processInput(map[string]any{"id": "8", "level": "info"}, *cfg)
+	if _, ok := rawLine(got); ok {
+		t.Errorf("expected a structured record, got %v", got)
+	}
+	if want := map[string]any{"id": "8"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func Test_processInput_keepEmpty(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+- pct:
+    percent: [used, total]
+`)
+	record := map[string]any{"used": 1, "total": 0}
+
+	cfg.OnEmpty = "original"
+	if got := processInput(record, *cfg); !reflect.DeepEqual(got, record) {
+		t.Errorf("got %v, want the original record %v", got, record)
+	}
+
+	cfg.KeepEmpty = true
+	if got, want := processInput(record, *cfg), map[string]any{}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keep-empty: got %v, want %v", got, want)
+	}
+
+	// Without any mappings the record still passes through whole.
+	noMappings := Config{MatchRule: "all", KeepEmpty: true}
+	if got := processInput(record, noMappings); !reflect.DeepEqual(got, record) {
+		t.Errorf("keep-empty without mappings: got %v, want %v", got, record)
+	}
+}
+
+func Test_processInput_emptyOutput(t *testing.T) {
+	record := map[string]any{"used": 1, "total": 0}
+
+	t.Run("no mappings configured", func(t *testing.T) {
+		cfg := mustConfig(t, `
+specific-outputs:
+- field: kind
+  eq: audit
+  output:
+  - pct:
+      percent: [used, total]
+`)
+		// No common mappings and no matching rule: the record passes through whole.
+		if got := processInput(record, *cfg); !reflect.DeepEqual(got, record) {
+			t.Errorf("got %v, want the original record %v", got, record)
+		}
+	})
+
+	tests := []struct {
+		onEmpty string
+		want    map[string]any
+	}{
+		{onEmpty: "", want: map[string]any{}},
+		{onEmpty: "emit", want: map[string]any{}},
+		{onEmpty: "drop", want: nil},
+		{onEmpty: "original", want: record},
+	}
+	for _, tt := range tests {
+		t.Run("mappings configured but all missed/"+tt.onEmpty, func(t *testing.T) {
+			cfg := mustConfig(t, `
+common-output:
+- pct:
+    percent: [used, total]
+`)
+			cfg.OnEmpty = tt.onEmpty
+			if got := processInput(record, *cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processInput_ruleCapture(t *testing.T) {
+	cfg := mustConfig(t, `
+specific-outputs:
+- field: path
+  capture: ^/api/(v\d+)/(\w+)
+  output:
+  - version: $1
+  - resource: $2
+  - route:
+      version: $1
+      name: resources.$2
+  - id:
+      src: path
+      regex: /(\d+)$
+      value: id-$1
+`)
+
+	record := map[string]any{"path": "/api/v2/users/42", "resources": map[string]any{"users": "Users"}}
+	got := processInput(record, *cfg)
+	want := map[string]any{
+		"version":  "v2",
+		"resource": "users",
+		"route":    OutputMap{"version": "v2", "name": "Users"},
+		"id":       "id-42",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The capture regex must match for the rule to apply.
+	got = processInput(map[string]any{"path": "/health"}, *cfg)
+	if want := map[string]any{"path": "/health"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func Test_applyMapping(t *testing.T) {
 	t.Run("string path mapping", func(t *testing.T) {
 		in := map[string]any{"foo": 42}
@@ -206,6 +440,84 @@ func Test_getValueByPath(t *testing.T) {
 			path:   "",
 			want:   nil,
 		},
+		{
+			name:   "array index",
+			record: map[string]any{"items": []any{map[string]any{"name": "a"}, map[string]any{"name": "b"}}},
+			path:   "items.1.name",
+			want:   "b",
+		},
+		{
+			name:   "array index between map steps",
+			record: map[string]any{"order": map[string]any{"lines": []any{"x", "y", map[string]any{"sku": "Z9"}}}},
+			path:   "order.lines.2.sku",
+			want:   "Z9",
+		},
+		{
+			name:   "array element",
+			record: map[string]any{"tags": []any{"a", "b"}},
+			path:   "tags.0",
+			want:   "a",
+		},
+		{
+			name:   "array index out of range",
+			record: map[string]any{"tags": []any{"a", "b"}},
+			path:   "tags.2",
+			want:   nil,
+		},
+		{
+			name:   "negative array index",
+			record: map[string]any{"tags": []any{"a", "b"}},
+			path:   "tags.-1",
+			want:   nil,
+		},
+		{
+			name:   "non-numeric segment on array",
+			record: map[string]any{"tags": []any{"a", "b"}},
+			path:   "tags.first",
+			want:   nil,
+		},
+		{
+			name:   "numeric segment on non-array",
+			record: map[string]any{"foo": "bar"},
+			path:   "foo.0",
+			want:   nil,
+		},
+		{
+			name:   "wildcard over array",
+			record: map[string]any{"lines": []any{map[string]any{"sku": "A1"}, map[string]any{"qty": 2}, map[string]any{"sku": "B2"}}},
+			path:   "lines.*.sku",
+			want:   []any{"A1", "B2"},
+		},
+		{
+			name:   "trailing wildcard",
+			record: map[string]any{"tags": []any{"a", "b"}},
+			path:   "tags.*",
+			want:   []any{"a", "b"},
+		},
+		{
+			name:   "nested wildcards",
+			record: map[string]any{"orders": []any{map[string]any{"lines": []any{map[string]any{"sku": "A1"}}}, map[string]any{"lines": []any{map[string]any{"sku": "B2"}, map[string]any{"sku": "C3"}}}}},
+			path:   "orders.*.lines.*.sku",
+			want:   []any{[]any{"A1"}, []any{"B2", "C3"}},
+		},
+		{
+			name:   "wildcard with no matches",
+			record: map[string]any{"lines": []any{map[string]any{"qty": 1}}},
+			path:   "lines.*.sku",
+			want:   []any{},
+		},
+		{
+			name:   "wildcard over non-array",
+			record: map[string]any{"lines": map[string]any{"sku": "A1"}},
+			path:   "lines.*.sku",
+			want:   nil,
+		},
+		{
+			name:   "numeric map key",
+			record: map[string]any{"codes": map[string]any{"0": "zero"}},
+			path:   "codes.0",
+			want:   "zero",
+		},
 	}
 
 	for _, tt := range tests {
@@ -269,7 +581,7 @@ func Test_lookupValueByPath(t *testing.T) {
 func testReadJSONInput(t *testing.T, jsonString string, expectedCount int, expectedType InputType) []map[string]any {
 	t.Helper()
 
-	// Mock stdin
+	// Mock input
 	r, w, err := os.Pipe()
 	if err != nil {
 		t.Fatalf("os.Pipe failed: %v", err)
@@ -283,17 +595,13 @@ func testReadJSONInput(t *testing.T, jsonString string, expectedCount int, expec
 	}
 	w.Close()
 
-	origStdin := os.Stdin
-	os.Stdin = r
-	defer func() { os.Stdin = origStdin }()
-
 	// Setup channels and config
 	objs := make(chan map[string]any, 10)
 	inputTypeChan := make(chan InputType, 1)
 	config := Config{InputFormat: "json", MatchRule: "all"}
 
 	// Run the function
-	readJSONInput(objs, inputTypeChan, config)
+	readJSONInput(r, objs, inputTypeChan, config)
 
 	// Collect results
 	var results []map[string]any
@@ -354,7 +662,7 @@ func TestReadJSONInput_ArrayOfObjects(t *testing.T) {
 func testReadYAMLInput(t *testing.T, yamlString string, expectedCount int, expectedType InputType) []map[string]any {
 	t.Helper()
 
-	// Mock stdin
+	// Mock input
 	r, w, err := os.Pipe()
 	if err != nil {
 		t.Fatalf("os.Pipe failed: %v", err)
@@ -367,17 +675,13 @@ func testReadYAMLInput(t *testing.T, yamlString string, expectedCount int, expec
 	}
 	w.Close()
 
-	origStdin := os.Stdin
-	os.Stdin = r
-	defer func() { os.Stdin = origStdin }()
-
 	// Setup channels and config
 	objs := make(chan map[string]any, 10)
 	inputTypeChan := make(chan InputType, 1)
 	config := Config{MatchRule: "all"} // A minimal config
 
 	// Run the function in a goroutine
-	go readYAMLInput(objs, inputTypeChan, config)
+	go readYAMLInput(r, objs, inputTypeChan, config)
 
 	// Collect all records from the objs channel until it's closed.
 	var results []map[string]any
@@ -511,15 +815,11 @@ func testReadJSONLInput(t *testing.T, jsonlString string, expectedCount int, exp
 	}
 	w.Close()
 
-	origStdin := os.Stdin
-	os.Stdin = r
-	defer func() { os.Stdin = origStdin }()
-
 	objs := make(chan map[string]any, 10)
 	inputTypeChan := make(chan InputType, 1)
 	config := Config{MatchRule: "all"}
 
-	go readJSONLInput(objs, inputTypeChan, config)
+	go readJSONLInput(r, objs, inputTypeChan, config)
 
 	var results []map[string]any
 	for obj := range objs {
@@ -559,6 +859,97 @@ func TestReadJSONLInput(t *testing.T) {
 	}
 }
 
+func TestReadJSONLInput_repair(t *testing.T) {
+	run := func(t *testing.T, input string, repair bool) ([]map[string]any, string) {
+		t.Helper()
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("os.Pipe failed: %v", err)
+		}
+		if _, err := w.Write([]byte(input)); err != nil {
+			t.Fatalf("writing to pipe failed: %v", err)
+		}
+		w.Close()
+
+		var logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		defer log.SetOutput(os.Stderr)
+
+		objs := make(chan map[string]any, 10)
+		inputTypeChan := make(chan InputType, 1)
+		config := Config{MatchRule: "all", Repair: repair}
+
+		go readJSONLInput(r, objs, inputTypeChan, config)
+
+		var results []map[string]any
+		for obj := range objs {
+			results = append(results, obj)
+		}
+		return results, logBuf.String()
+	}
+
+	t.Run("truncated final line is dropped silently", func(t *testing.T) {
+		input := "{\"id\": 1}\n{\"id\": 2}\n{\"id\": 3, \"na"
+		results, logs := run(t, input, true)
+		if len(results) != 2 {
+			t.Errorf("got %d records, want 2", len(results))
+		}
+		if logs != "" {
+			t.Errorf("expected no log output, got %q", logs)
+		}
+	})
+
+	t.Run("truncated final line is reported without repair", func(t *testing.T) {
+		input := "{\"id\": 1}\n{\"id\": 2, \"na"
+		results, logs := run(t, input, false)
+		if len(results) != 1 {
+			t.Errorf("got %d records, want 1", len(results))
+		}
+		if !strings.Contains(logs, "Error parsing JSON") {
+			t.Errorf("expected parse error to be logged, got %q", logs)
+		}
+	})
+
+	t.Run("bad middle line is still reported", func(t *testing.T) {
+		input := "{\"id\": 1}\n{bad json}\n{\"id\": 3}\n"
+		results, logs := run(t, input, true)
+		if len(results) != 2 {
+			t.Errorf("got %d records, want 2", len(results))
+		}
+		if !strings.Contains(logs, "Error parsing JSON") {
+			t.Errorf("expected parse error to be logged, got %q", logs)
+		}
+	})
+}
+
+func TestReadJSONLInput_stripPrefix(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte("42\t{\"k\": 1}\n43\t{\"k\": 2, \"note\": \"a\\tb\"}\n{\"k\": 3}\n"))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := Config{MatchRule: "all", StripPrefixTo: unescape(`\t`), PrefixField: "offset"}
+
+	go readJSONLInput(r, objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{
+		{"k": float64(1), "offset": "42"},
+		{"k": float64(2), "note": "a\tb", "offset": "43"},
+		{"k": float64(3)},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
 func testReadCSVInput(t *testing.T, csvString string, expectedCount int, expectedType InputType) []map[string]any {
 	t.Helper()
 
@@ -574,15 +965,11 @@ func testReadCSVInput(t *testing.T, csvString string, expectedCount int, expecte
 	}
 	w.Close()
 
-	origStdin := os.Stdin
-	os.Stdin = r
-	defer func() { os.Stdin = origStdin }()
-
 	objs := make(chan map[string]any, 10)
 	inputTypeChan := make(chan InputType, 1)
 	config := Config{MatchRule: "all"}
 
-	go readCSVInput(objs, inputTypeChan, config)
+	go readCSVInput(r, objs, inputTypeChan, config)
 
 	var results []map[string]any
 	for obj := range objs {
@@ -626,6 +1013,115 @@ func TestReadCSVInput(t *testing.T) {
 	}
 }
 
+func Test_parseFixedColumns(t *testing.T) {
+	got, err := parseFixedColumns("name:0-10, age:10-13")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []fixedColumn{{name: "name", start: 0, end: 10}, {name: "age", start: 10, end: 13}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{"", "name", "name:0", "name:a-3", "name:5-2", ":0-3"} {
+		if _, err := parseFixedColumns(spec); err == nil {
+			t.Errorf("expected error for spec %q", spec)
+		}
+	}
+}
+
+func TestReadFixedInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte("Alice      30 \nBob        7\n"))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := Config{MatchRule: "all", FixedCols: "name:0-11,age:11-14"}
+
+	go readFixedInput(r, objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+
+	if gotType := <-inputTypeChan; gotType != StreamInput {
+		t.Errorf("got input type %v, want %v", gotType, StreamInput)
+	}
+	want := []map[string]any{
+		{"name": "Alice", "age": "30"},
+		{"name": "Bob", "age": "7"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func Test_main_jsonIndent(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		want  string
+	}{
+		{name: "default", want: "{\n  \"id\": 1\n}"},
+		{name: "zero", flags: []string{"-json-indent", "0"}, want: "{\n\"id\": 1\n}"},
+		{name: "tab", flags: []string{"-json-indent", "tab"}, want: "{\n\t\"id\": 1\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origArgs := os.Args
+			origCommandLine := flag.CommandLine
+			defer func() {
+				os.Args = origArgs
+				flag.CommandLine = origCommandLine
+			}()
+
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in.json")
+			if err := os.WriteFile(in, []byte(`{"id": 1}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(dir, "out.json")
+
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			os.Args = append([]string{os.Args[0], "-f", in, "-i", "json", "-o", "jsonp", "-out", out}, tt.flags...)
+
+			main()
+
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatalf("reading output: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseIndent(t *testing.T) {
+	tests := map[string]string{
+		"2":   "  ",
+		"4":   "    ",
+		"0":   "",
+		"tab": "\t",
+		`\t`:  "\t",
+		"-->": "-->",
+	}
+	for in, want := range tests {
+		if got, err := parseIndent(in); err != nil || got != want {
+			t.Errorf("parseIndent(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseIndent("-1"); err == nil {
+		t.Errorf("parseIndent(\"-1\") should fail")
+	}
+}
+
 func Test_getConfig(t *testing.T) {
 	// Backup original args and command line
 	origArgs := os.Args
@@ -964,3 +1460,550 @@ func Test_applyMapping_edgecases(t *testing.T) {
 }
 
 
+func TestReadTSVInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte("id\tname\n1\tSmith, Jane\n2\ttwo\n"))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := Config{MatchRule: "all", InputFormat: "tsv"}
+
+	go readCSVInput(r, objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{
+		{"id": "1", "name": "Smith, Jane"},
+		{"id": "2", "name": "two"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func TestReadCSVInput_transpose(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte("key,value\nhost,db1\nport,5432\nhost,db2\n"))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := Config{MatchRule: "all", InputFormat: "csv", Transpose: true}
+
+	go readCSVInput(r, objs, inputTypeChan, config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	if it := <-inputTypeChan; it != SingletonInput {
+		t.Errorf("input type = %v, want SingletonInput", it)
+	}
+	want := []map[string]any{{"host": "db2", "port": "5432"}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func TestReadCSVInput_inferTypes(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte("name,age,score,active,zip\nann,30,9.5,true,02134\nbob,,n/a,no,-7\n"))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := mustConfig(t, `
+csv-infer-types: true
+specific-outputs:
+  - field: age
+    gte: 18
+    output:
+      - name: name
+      - adult: "yes"
+`)
+	config.MatchRule = "all"
+	config.InputFormat = "csv"
+
+	go readCSVInput(r, objs, inputTypeChan, *config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{
+		{"name": "ann", "adult": "yes"},
+		{"name": "bob", "age": "", "score": "n/a", "active": "no", "zip": -7},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func TestReadCSVInput_inferTypesMappings(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Write([]byte("id,active\n7,1\n42,0\n"))
+	w.Close()
+
+	objs := make(chan map[string]any, 10)
+	inputTypeChan := make(chan InputType, 1)
+	config := mustConfig(t, `
+csv-infer-types: true
+common-output:
+  - id: {src: id, padleft: [4, "0"]}
+  - active: {src: active, to-bool: true}
+`)
+	config.InputFormat = "csv"
+
+	go readCSVInput(r, objs, inputTypeChan, *config)
+
+	var results []map[string]any
+	for obj := range objs {
+		results = append(results, obj)
+	}
+	want := []map[string]any{
+		{"id": "0007", "active": true},
+		{"id": "0042", "active": false},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+}
+
+func Test_csvValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"30", 30},
+		{"-4", -4},
+		{"0", 0},
+		{"9.5", 9.5},
+		{"0.25", 0.25},
+		{"1e3", 1000.0},
+		{"true", true},
+		{"FALSE", false},
+		{"02134", "02134"},
+		{"NaN", "NaN"},
+		{"Inf", "Inf"},
+		{"", ""},
+		{"yes", "yes"},
+	}
+	for _, tt := range tests {
+		if got := csvValue(tt.in, true); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("csvValue(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+	if got := csvValue("30", false); got != "30" {
+		t.Errorf("without inference: got %#v, want \"30\"", got)
+	}
+}
+
+func Test_main_repeat(t *testing.T) {
+	origStdin := os.Stdin
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Stdin = origStdin
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	os.Stdin = inR
+	go func() {
+		inW.Write([]byte(`{"n": 1}` + "\n" + `{"n": 2}` + "\n" + `{"n": 3}` + "\n"))
+		inW.Close()
+	}()
+
+	out := filepath.Join(t.TempDir(), "out.jsonl")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-i", "jsonl", "-o", "jsonl", "-repeat", "2", "-out", out}
+
+	main()
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	records := `{"n":1}` + "\n" + `{"n":2}` + "\n" + `{"n":3}` + "\n"
+	if want := records + records; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_applyFieldMappings_configLoaded(t *testing.T) {
+	record := map[string]any{
+		"user": map[string]any{"name": "Ada Lovelace", "id": "u1"},
+		"tags": []any{"x", "y"},
+	}
+	want := map[string]any{
+		"id":   "u1",
+		"last": "Lovelace",
+		"meta": OutputMap{"first-tag": "y", "kind": "person"},
+	}
+
+	cfg := mustConfig(t, `
+common-output:
+  - id: user.id
+  - last:
+      src: user.name
+      word: 2
+  - meta:
+      first-tag: tags.1
+      kind: person
+`)
+	t.Run("config loaded", func(t *testing.T) {
+		out := map[string]any{}
+		applyFieldMappings(record, out, convertFieldMappings(cfg.CommonOutput), nil)
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+
+	constructed := []FieldMapping{
+		{Key: "id", Output: "user.id"},
+		{Key: "last", Output: OutputMap{"src": "user.name", "word": 2}},
+		{Key: "meta", Output: OutputMap{"first-tag": "tags.1", "kind": "person"}},
+	}
+	t.Run("constructed", func(t *testing.T) {
+		out := map[string]any{}
+		applyFieldMappings(record, out, constructed, nil)
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+
+	word := 2
+	untyped := []FieldMapping{
+		{Key: "id", Output: "user.id"},
+		{Key: "last", Output: &MappingDefinition{Src: "user.name", Word: &word}},
+		{Key: "meta", Output: map[string]any{"first-tag": "tags.1", "kind": "person"}},
+	}
+	t.Run("plain maps and definitions", func(t *testing.T) {
+		out := map[string]any{}
+		applyFieldMappings(record, out, untyped, nil)
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+}
+
+func Test_applyFieldMappings_yamlDefinitions(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+  - project: resource.labels.project_id
+  - missing: no.such.path
+  - log-name:
+      src: logName
+      regex: projects/.*?/logs/(.*)
+      value: $1
+  - user-name:
+      src: raw
+      regex: id=(\w+)
+      lookup-path: entities.$1.name
+  - meta:
+      service: resource.type
+      log:
+        src: logName
+        regex: /logs/(.*)
+        value: log-$1
+`)
+	record := map[string]any{
+		"logName":  "projects/p1/logs/syslog",
+		"raw":      "login id=u7",
+		"entities": map[string]any{"u7": map[string]any{"name": "Ada"}},
+		"resource": map[string]any{"type": "gce_instance", "labels": map[string]any{"project_id": "p1"}},
+	}
+	want := map[string]any{
+		"project":   "p1",
+		"missing":   "no.such.path",
+		"log-name":  "syslog",
+		"user-name": "Ada",
+		"meta":      OutputMap{"service": "gce_instance", "log": "log-syslog"},
+	}
+
+	out := map[string]any{}
+	applyFieldMappings(record, out, convertFieldMappings(cfg.CommonOutput), nil)
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+}
+
+func Test_processInput_nestedRuleOutput(t *testing.T) {
+	cfg := mustConfig(t, `
+match-rule: drop-no-match
+specific-outputs:
+- field: kind
+  eq: request
+  output:
+  - http:
+      method: req.method
+      client:
+        ip: req.ip
+        agent:
+          src: req.ua
+          word: 1
+          sep: /
+      source: access-log
+`)
+	record := map[string]any{
+		"kind": "request",
+		"req":  map[string]any{"method": "GET", "ip": "10.0.0.1", "ua": "curl/8.1"},
+	}
+	got := processInput(record, *cfg)
+	want := map[string]any{
+		"http": OutputMap{
+			"method": "GET",
+			"client": OutputMap{"ip": "10.0.0.1", "agent": "curl"},
+			"source": "access-log",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func Test_loadConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	fragments := map[string]string{
+		"10-common.yaml": "common-output:\n  - id: id\n",
+		"20-rules.yaml":  "match-rule: drop-no-match\ncommon-output:\n  - name: name\nspecific-outputs:\n  - field: kind\n    eq: user\n    output:\n      - user: true\n",
+		"ignored.yml":    "match-rule: all\n",
+	}
+	for name, content := range fragments {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := Config{MatchRule: "all", CommonOutput: []OutputMap{{"base": "base"}}, InputFormat: "json"}
+	if err := loadConfigDir(&config, dir); err != nil {
+		t.Fatalf("loadConfigDir() error: %v", err)
+	}
+	if config.MatchRule != "drop-no-match" {
+		t.Errorf("MatchRule = %q, want the later fragment's drop-no-match", config.MatchRule)
+	}
+	wantCommon := []OutputMap{{"base": "base"}, {"id": "id"}, {"name": "name"}}
+	if !reflect.DeepEqual(config.CommonOutput, wantCommon) {
+		t.Errorf("CommonOutput = %v, want %v", config.CommonOutput, wantCommon)
+	}
+	if len(config.SpecificOutputs) != 1 || config.SpecificOutputs[0].Field != "kind" {
+		t.Errorf("SpecificOutputs = %v, want the one rule", config.SpecificOutputs)
+	}
+	if config.InputFormat != "json" {
+		t.Errorf("InputFormat = %q, command-line settings must be kept", config.InputFormat)
+	}
+
+	t.Run("empty directory", func(t *testing.T) {
+		config := Config{MatchRule: "all"}
+		if err := loadConfigDir(&config, t.TempDir()); err != nil {
+			t.Fatalf("loadConfigDir() error: %v", err)
+		}
+		if !reflect.DeepEqual(config, Config{MatchRule: "all"}) {
+			t.Errorf("config changed to %+v", config)
+		}
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		var config Config
+		if err := loadConfigDir(&config, filepath.Join(dir, "missing")); err == nil {
+			t.Error("expected an error for a missing directory")
+		}
+	})
+}
+
+func Test_unmarshalConfig_json(t *testing.T) {
+	jsonConfig := `{
+	"match-rule": "drop-no-match",
+	"common-output": [
+		{"id": "id"},
+		{"last": {"src": "name", "word": 2}},
+		{"meta": {"kind": "type"}}
+	],
+	"specific-outputs": [
+		{"field": "type", "eq": "user", "output": [{"role": "member"}]}
+	]
+}`
+	yamlConfig := `
+match-rule: drop-no-match
+common-output:
+  - id: id
+  - last:
+      src: name
+      word: 2
+  - meta:
+      kind: type
+specific-outputs:
+  - field: type
+    eq: user
+    output:
+      - role: member
+`
+	var fromJSON, fromYAML Config
+	if err := unmarshalConfig("config.json", []byte(jsonConfig), &fromJSON); err != nil {
+		t.Fatalf("JSON config error: %v", err)
+	}
+	if err := unmarshalConfig("config.yaml", []byte(yamlConfig), &fromYAML); err != nil {
+		t.Fatalf("YAML config error: %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("JSON config %+v differs from YAML config %+v", fromJSON, fromYAML)
+	}
+
+	record := map[string]any{"id": "u1", "name": "Ada Lovelace", "type": "user"}
+	want := map[string]any{"id": "u1", "last": "Lovelace", "meta": OutputMap{"kind": "user"}, "role": "member"}
+	if got := processInput(record, fromJSON); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var invalid Config
+	if err := unmarshalConfig("config.json", []byte("match-rule: all\n"), &invalid); err == nil {
+		t.Error("expected an error for YAML syntax in a .json config")
+	}
+}
+
+func Test_getConfig_inline(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "trmg-test-config-*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString("match-rule: drop-no-match\nclone-original: true\ncommon-output:\n  - from-file: a\n")
+	tmpFile.Close()
+
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
+	origCommandLine := flag.CommandLine
+	defer func() { flag.CommandLine = origCommandLine }()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-i", "json", "-o", "csv", "-e", "common-output: [{id: user.id}]"}
+	config := getConfig()
+	want := []OutputMap{{"id": "user.id"}}
+	if !reflect.DeepEqual(config.CommonOutput, want) {
+		t.Errorf("CommonOutput = %v, want %v", config.CommonOutput, want)
+	}
+	if config.MatchRule != "all" {
+		t.Errorf("MatchRule = %q, want the default", config.MatchRule)
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-c", tmpFile.Name(), "-e", "common-output: [{id: user.id}]\nclone-original: false"}
+	config = getConfig()
+	if !reflect.DeepEqual(config.CommonOutput, want) {
+		t.Errorf("CommonOutput = %v, want -e to override the file's %v", config.CommonOutput, want)
+	}
+	if config.CloneOriginal {
+		t.Error("CloneOriginal = true, want -e to override the file")
+	}
+	if config.MatchRule != "drop-no-match" {
+		t.Errorf("MatchRule = %q, want the file's drop-no-match", config.MatchRule)
+	}
+}
+
+func Test_processInput_noFallback(t *testing.T) {
+	record := map[string]any{"ssn": "123-45-6789", "kind": "person"}
+	cfg := mustConfig(t, `
+specific-outputs:
+- field: kind
+  eq: audit
+  output:
+  - actor: user
+`)
+	cfg.NoFallback = true
+
+	for _, tt := range []struct {
+		onEmpty string
+		want    map[string]any
+	}{
+		{onEmpty: "", want: map[string]any{}},
+		{onEmpty: "emit", want: map[string]any{}},
+		{onEmpty: "drop", want: nil},
+	} {
+		t.Run("no mappings apply/"+tt.onEmpty, func(t *testing.T) {
+			cfg.OnEmpty = tt.onEmpty
+			got := processInput(record, *cfg)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if _, leaked := got["ssn"]; leaked {
+				t.Errorf("raw record leaked: %v", got)
+			}
+		})
+	}
+
+	t.Run("empty config", func(t *testing.T) {
+		got := processInput(record, Config{MatchRule: "all", NoFallback: true})
+		if !reflect.DeepEqual(got, map[string]any{}) {
+			t.Errorf("got %v, want {}", got)
+		}
+	})
+
+	t.Run("mapped fields still output", func(t *testing.T) {
+		in := map[string]any{"ssn": "123-45-6789", "kind": "audit", "user": "alice"}
+		got := processInput(in, *cfg)
+		if want := map[string]any{"actor": "alice"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_processInput_keepDropKeys(t *testing.T) {
+	record := map[string]any{"id": "1", "name": "a", "debug": "x", "trace": "y"}
+	tests := []struct {
+		name string
+		yaml string
+		want map[string]any
+	}{
+		{
+			name: "drop-keys on passthrough",
+			yaml: "drop-keys: [debug, trace]",
+			want: map[string]any{"id": "1", "name": "a"},
+		},
+		{
+			name: "keep-keys on passthrough",
+			yaml: "keep-keys: [id, missing]",
+			want: map[string]any{"id": "1"},
+		},
+		{
+			name: "keep-keys wins over drop-keys",
+			yaml: "keep-keys: [id, debug]\ndrop-keys: [debug]",
+			want: map[string]any{"id": "1", "debug": "x"},
+		},
+		{
+			name: "applies after mappings",
+			yaml: "clone-original: true\ncommon-output:\n- label: name\ndrop-keys: [debug, trace, name]",
+			want: map[string]any{"id": "1", "label": "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := processInput(record, *mustConfig(t, tt.yaml))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if len(record) != 4 {
+		t.Errorf("input record modified: %v", record)
+	}
+}
@@ -0,0 +1,1070 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/yaml.v3"
+)
+
+// definitionKeys are the keys that, together with "src", mark an OutputMap as a
+// MappingDefinition rather than a nested output object.
+var definitionKeys = []string{"regex", "lookup-path", "word", "to-bool", "clamp", "normalize", "query-param", "url-parse", "domain", "func", "mask", "strip-ansi", "count-matches", "lookup", "substr", "auto-time", "age", "as-array", "unique", "sort", "padleft", "padright", "type", "filter", "default"}
+
+// sourcelessKeys mark an OutputMap as a MappingDefinition even without "src",
+// when the key's value has the type the mapping takes. A plain nested object
+// may use the same names for fields holding paths, e.g. {min: lo, max: hi}.
+var sourcelessKeys = map[string]func(v any) bool{
+	"key-regex":   isString,
+	"schema-sig":  isBool,
+	"coalesce":    isList,
+	"percent":     isList,
+	"rownum-by":   isString,
+	"running-sum": isString,
+	"min":         isList,
+	"max":         isList,
+}
+
+func isString(v any) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+func isBool(v any) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+func isList(v any) bool {
+	_, ok := v.([]any)
+	return ok
+}
+
+// isMappingDefinition reports whether an OutputMap describes a derived value
+// rather than a nested output object.
+func isMappingDefinition(om OutputMap) bool {
+	for k, typeOK := range sourcelessKeys {
+		if v, ok := om[k]; ok && typeOK(v) {
+			return true
+		}
+	}
+	// An explicit nested object, a condition, a switch, or a secret; plain
+	// "object", "if", "switch", and "secret" keys are just fields.
+	if _, ok := asStringMap(om["object"]); ok {
+		return true
+	}
+	if _, ok := asStringMap(om["if"]); ok {
+		return true
+	}
+	if hasKeys(om, "switch", "cases") {
+		return true
+	}
+	if hasKeys(om, "secret", "from") {
+		return true
+	}
+	if !hasKeys(om, "src") {
+		return false
+	}
+	for _, k := range definitionKeys {
+		if hasKeys(om, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// newMappingDefinition decodes an OutputMap into its typed MappingDefinition.
+func newMappingDefinition(om OutputMap) (*MappingDefinition, error) {
+	data, err := yaml.Marshal(om)
+	if err != nil {
+		return nil, err
+	}
+	var md MappingDefinition
+	if err := yaml.Unmarshal(data, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// compileMappings decodes every mapping definition in the config's outputs
+// into a *MappingDefinition, with its regexes compiled, so that this is done
+// once when the config is loaded rather than for every record. It is safe to
+// call again after more config is merged in. It reports the first definition
+// that does not decode.
+func compileMappings(config *Config) error {
+	for _, om := range config.CommonOutput {
+		if err := compileSpecs(om); err != nil {
+			return fmt.Errorf("common-output %w", err)
+		}
+	}
+	for i := range config.SpecificOutputs {
+		rule := &config.SpecificOutputs[i]
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("specific-outputs rule %s: output %w", rule.Label(i), err)
+		}
+	}
+	return nil
+}
+
+// compileSpecs compiles each mapping spec in om in place.
+func compileSpecs(om OutputMap) error {
+	for _, k := range slices.Sorted(maps.Keys(om)) {
+		spec, err := compileSpec(om[k])
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		om[k] = spec
+	}
+	return nil
+}
+
+// compileSpec returns a mapping spec with mapping definitions decoded and
+// compiled, at any depth of nested output objects.
+func compileSpec(spec any) (any, error) {
+	switch v := spec.(type) {
+	case OutputMap:
+		if !isMappingDefinition(v) {
+			return v, compileSpecs(v)
+		}
+		md, err := newMappingDefinition(v)
+		if err != nil {
+			return nil, err
+		}
+		md.spec = v
+		if err := md.compile(); err != nil {
+			return nil, err
+		}
+		return md, nil
+	case map[string]any:
+		return compileSpec(OutputMap(v))
+	}
+	return spec, nil
+}
+
+// compile compiles the definition's regexes and conditions, loads its secrets
+// file, and compiles the mappings of its nested object.
+func (md *MappingDefinition) compile() error {
+	if md.Secret != "" {
+		if _, err := loadDotenv(md.From); err != nil {
+			return fmt.Errorf("secrets file: %w", err)
+		}
+	}
+	md.regex = precompileRegex(md.Regex)
+	md.countMatch = precompileRegex(md.CountMatch)
+	md.keyRegex = precompileRegex(md.KeyRegex)
+	if md.If != nil {
+		md.If.compile()
+	}
+	if md.Filter != nil {
+		md.Filter.compile()
+	}
+	return compileSpecs(md.Object)
+}
+
+// resolve computes the value of the mapping for a record in three stages:
+//
+//  1. Source: the extractor (regex, word, lookup, …) produces a value from src,
+//     or, when no extractor is set, the value at src is used as-is.
+//  2. Transform: func, if set, is applied to the source value, and then the
+//     result is converted to type, if set.
+//  3. Fallback: if either stage produced nothing, the coalesce paths are tried
+//     in order, then the default.
+//
+// The boolean result is false when the output key should be left unset.
+func (md *MappingDefinition) resolve(in map[string]any) (any, bool) {
+	val, ok := md.source(in)
+	if ok && md.Func != "" {
+		val, ok = md.applyFunc(val)
+	}
+	if ok && md.Type != "" {
+		val, ok = convertType(val, md.Type)
+	}
+	if ok {
+		return val, true
+	}
+	for _, path := range md.Coalesce {
+		if val := getValueByPath(in, path); val != nil && val != "" {
+			return val, true
+		}
+	}
+	if md.Default != nil {
+		return md.Default, true
+	}
+	return nil, false
+}
+
+// source extracts the mapping's value from the record. At most one extractor
+// applies; if several are set, the first in this switch wins.
+func (md *MappingDefinition) source(in map[string]any) (any, bool) {
+	switch {
+	case md.Regex != "":
+		return md.applyRegex(in)
+	case md.Word != nil:
+		return md.applyWord(in)
+	case md.ToBool:
+		return parseBool(getValueByPath(in, md.Src))
+	case md.Clamp != nil:
+		return md.applyClamp(in)
+	case md.Normalize != "":
+		return md.applyNormalize(in)
+	case md.QueryParam != "":
+		return md.applyQueryParam(in)
+	case md.URLParse:
+		return md.applyURLParse(in)
+	case md.Domain:
+		return md.applyDomain(in)
+	case md.Mask != "":
+		return md.applyMask(in)
+	case md.CountMatch != "":
+		return md.applyCountMatches(in)
+	case md.Unique:
+		srcVal := getValueByPath(in, md.Src)
+		if arr, ok := srcVal.([]any); ok {
+			return uniqueElements(arr), true
+		}
+		return srcVal, srcVal != nil
+	case md.Sort != "":
+		return md.applySort(in)
+	case md.StripANSI:
+		srcVal := getValueByPath(in, md.Src)
+		if str, ok := srcVal.(string); ok {
+			return ansiRegex.ReplaceAllString(str, ""), true
+		}
+		return srcVal, srcVal != nil
+	case md.Lookup != nil:
+		val := getValueByPath(in, md.Src)
+		if val == nil {
+			return nil, false
+		}
+		mapped, ok := md.Lookup[valueText(val)]
+		return mapped, ok
+	case md.If != nil:
+		if md.If.Check(in) {
+			return md.Then, md.Then != nil
+		}
+		return md.Else, md.Else != nil
+	case md.Secret != "":
+		values, err := loadDotenv(md.From)
+		if err != nil {
+			log.Printf("Error reading secrets file: %v", err)
+		}
+		val, ok := values[md.Secret]
+		return val, ok
+	case md.Switch != "":
+		val := getValueByPath(in, md.Switch)
+		if val == nil {
+			return nil, false
+		}
+		mapped, ok := md.Cases[valueText(val)]
+		return mapped, ok
+	case md.Object != nil:
+		obj := make(OutputMap)
+		for k, spec := range md.Object {
+			applyMapping(k, in, obj, spec)
+		}
+		return obj, true
+	case md.SchemaSig:
+		if md.Src == "" {
+			return schemaSignature(in), true
+		}
+		return schemaSignature(getValueByPath(in, md.Src)), true
+	case md.AsArray:
+		// Arrays are kept, a missing or null value becomes [], and any
+		// other value is wrapped.
+		switch val := getValueByPath(in, md.Src).(type) {
+		case []any:
+			return val, true
+		case nil:
+			return []any{}, true
+		default:
+			return []any{val}, true
+		}
+	case md.Filter != nil:
+		return md.applyFilter(in)
+	case md.RownumBy != "":
+		return newRownumRef(in, md.RownumBy), true
+	case md.RunningSum != "":
+		return newRunningSumRef(in, md.RunningSum), true
+	case md.Age:
+		return md.applyAge(in)
+	case md.AutoTime:
+		return md.applyAutoTime(in)
+	case md.PadLeft != nil:
+		return md.applyPad(in, md.PadLeft, true)
+	case md.PadRight != nil:
+		return md.applyPad(in, md.PadRight, false)
+	case md.Substr != nil:
+		return md.applySubstr(in)
+	case md.Percent != nil:
+		return md.applyPercent(in)
+	case md.Min != nil:
+		return extremeValue(in, md.Min, func(a, b float64) bool { return a < b })
+	case md.Max != nil:
+		return extremeValue(in, md.Max, func(a, b float64) bool { return a > b })
+	case md.Src != "":
+		val := getValueByPath(in, md.Src)
+		return val, val != nil
+	}
+	return nil, false
+}
+
+// applyCountMatches counts the non-overlapping matches of the pattern in the
+// source string. Sources that aren't strings count as 0 matches.
+func (md *MappingDefinition) applyCountMatches(in map[string]any) (any, bool) {
+	re, err := regexOrCompile(md.countMatch, md.CountMatch)
+	if err != nil {
+		return nil, false
+	}
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return 0, true
+	}
+	return len(re.FindAllString(srcVal, -1)), true
+}
+
+// applyRegex substitutes the captured groups into the value template, or uses
+// them to build a lookup path that indexes back into the record.
+func (md *MappingDefinition) applyRegex(in map[string]any) (any, bool) {
+	re, err := regexOrCompile(md.regex, md.Regex)
+	if err != nil {
+		return nil, false
+	}
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	matches := re.FindStringSubmatch(srcVal)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	if md.LookupPath != "" {
+		return lookupValueByPath(in, expandCaptures(md.LookupPath, matches))
+	}
+	if md.Value == "" {
+		return nil, false
+	}
+	return expandCaptures(md.Value, matches), true
+}
+
+// applyWord picks the 1-based Nth token of the source, split on Sep or on
+// whitespace when no separator is given.
+func (md *MappingDefinition) applyWord(in map[string]any) (any, bool) {
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	var tokens []string
+	if md.Sep != "" {
+		tokens = strings.Split(srcVal, md.Sep)
+	} else {
+		tokens = strings.Fields(srcVal)
+	}
+	n := *md.Word
+	if n < 1 || n > len(tokens) {
+		return nil, false
+	}
+	return tokens[n-1], true
+}
+
+// copyMatchingKeys copies every top-level key of the source object whose name
+// matches KeyRegex into out, keeping the key names. The source is the record
+// itself when Src is empty. Nested objects are copied as-is, not searched.
+func (md *MappingDefinition) copyMatchingKeys(in, out map[string]any) {
+	re, err := regexOrCompile(md.keyRegex, md.KeyRegex)
+	if err != nil {
+		return
+	}
+	src := in
+	if md.Src != "" {
+		m, ok := asStringMap(getValueByPath(in, md.Src))
+		if !ok {
+			return
+		}
+		src = m
+	}
+	for k, v := range src {
+		if re.MatchString(k) {
+			out[k] = v
+		}
+	}
+}
+
+// applyClamp constrains a numeric source into the inclusive [min, max] range.
+func (md *MappingDefinition) applyClamp(in map[string]any) (any, bool) {
+	if len(md.Clamp) != 2 {
+		return nil, false
+	}
+	f, ok := toFloat(getValueByPath(in, md.Src))
+	if !ok {
+		return nil, false
+	}
+	return min(max(f, md.Clamp[0]), md.Clamp[1]), true
+}
+
+// normalizationForms maps the normalize option to its Unicode normalization form.
+var normalizationForms = map[string]norm.Form{
+	"nfc":  norm.NFC,
+	"nfd":  norm.NFD,
+	"nfkc": norm.NFKC,
+	"nfkd": norm.NFKD,
+}
+
+// applyNormalize applies Unicode normalization to a string source.
+func (md *MappingDefinition) applyNormalize(in map[string]any) (any, bool) {
+	form, ok := normalizationForms[strings.ToLower(md.Normalize)]
+	if !ok {
+		return nil, false
+	}
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	return form.String(srcVal), true
+}
+
+// applyQueryParam extracts a query parameter from a URL source.
+func (md *MappingDefinition) applyQueryParam(in map[string]any) (any, bool) {
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	u, err := url.Parse(srcVal)
+	if err != nil {
+		return nil, false
+	}
+	query := u.Query()
+	if !query.Has(md.QueryParam) {
+		return nil, false
+	}
+	return query.Get(md.QueryParam), true
+}
+
+// applyURLParse splits a URL source into a nested object of its components.
+// Query parameters become a map whose values are strings, or lists of strings
+// for repeated parameters.
+func (md *MappingDefinition) applyURLParse(in map[string]any) (any, bool) {
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	u, err := url.Parse(srcVal)
+	if err != nil {
+		return nil, false
+	}
+	query := make(OutputMap)
+	for k, vals := range u.Query() {
+		if len(vals) == 1 {
+			query[k] = vals[0]
+			continue
+		}
+		list := make([]any, len(vals))
+		for i, v := range vals {
+			list[i] = v
+		}
+		query[k] = list
+	}
+	return OutputMap{
+		"scheme":   u.Scheme,
+		"host":     u.Hostname(),
+		"port":     u.Port(),
+		"path":     u.Path,
+		"query":    query,
+		"fragment": u.Fragment,
+	}, true
+}
+
+// applyDomain extracts the lowercased host from a URL ("scheme://host/...")
+// or the part after the "@" of an email address.
+func (md *MappingDefinition) applyDomain(in map[string]any) (any, bool) {
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	srcVal = strings.TrimSpace(srcVal)
+	var domain string
+	if strings.Contains(srcVal, "://") {
+		u, err := url.Parse(srcVal)
+		if err != nil {
+			return nil, false
+		}
+		domain = u.Hostname()
+	} else if at := strings.LastIndex(srcVal, "@"); at > 0 {
+		domain = srcVal[at+1:]
+	}
+	if domain == "" || strings.ContainsAny(domain, " /@") {
+		return nil, false
+	}
+	return strings.ToLower(domain), true
+}
+
+// applyFunc applies a named string function to a string value: "upper",
+// "lower", or "title". Title case keeps any configured Acronyms in the case
+// they are listed in.
+func (md *MappingDefinition) applyFunc(val any) (any, bool) {
+	srcVal, ok := val.(string)
+	if !ok {
+		return nil, false
+	}
+	switch md.Func {
+	case "upper":
+		return strings.ToUpper(srcVal), true
+	case "lower":
+		return strings.ToLower(srcVal), true
+	case "title":
+		return titleCase(srcVal, md.Acronyms), true
+	}
+	return nil, false
+}
+
+var wordRegex = regexp.MustCompile(`[^\s_-]+`)
+
+// titleCase capitalizes the first letter of each word and lowercases the rest,
+// except for words matching an acronym, which are replaced by the acronym.
+func titleCase(s string, acronyms []string) string {
+	return wordRegex.ReplaceAllStringFunc(s, func(word string) string {
+		for _, acronym := range acronyms {
+			if strings.EqualFold(word, acronym) {
+				return acronym
+			}
+		}
+		r, size := utf8.DecodeRuneInString(word)
+		return string(unicode.ToUpper(r)) + strings.ToLower(word[size:])
+	})
+}
+
+// uniqueElements returns the elements of arr with repeats removed, keeping
+// the first of each. Elements are compared by their text.
+func uniqueElements(arr []any) []any {
+	seen := make(map[string]bool, len(arr))
+	unique := make([]any, 0, len(arr))
+	for _, elem := range arr {
+		key := valueText(elem)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, elem)
+		}
+	}
+	return unique
+}
+
+// applySort sorts an array of scalars "asc" or "desc": numerically if every
+// element is a number, otherwise by text. The source array is not modified,
+// and a value that is not an array passes through.
+func (md *MappingDefinition) applySort(in map[string]any) (any, bool) {
+	if md.Sort != "asc" && md.Sort != "desc" {
+		return nil, false
+	}
+	srcVal := getValueByPath(in, md.Src)
+	arr, ok := srcVal.([]any)
+	if !ok {
+		return srcVal, srcVal != nil
+	}
+	numeric := true
+	for _, elem := range arr {
+		if _, ok := toFloat(elem); !ok {
+			numeric = false
+			break
+		}
+	}
+	sorted := slices.Clone(arr)
+	slices.SortStableFunc(sorted, func(a, b any) int {
+		if numeric {
+			x, _ := toFloat(a)
+			y, _ := toFloat(b)
+			return cmp.Compare(x, y)
+		}
+		return strings.Compare(valueText(a), valueText(b))
+	})
+	if md.Sort == "desc" {
+		slices.Reverse(sorted)
+	}
+	return sorted, true
+}
+
+// compiledRegexes caches the result of compiling patterns that were not
+// compiled with the config, such as those built from rule captures, so that
+// each is compiled once per run rather than once per record. The cache is
+// cleared when it holds maxCompiledRegexes patterns, to bound its size.
+var compiledRegexes = struct {
+	sync.RWMutex
+	results map[string]compiledRegex
+}{results: map[string]compiledRegex{}}
+
+const maxCompiledRegexes = 1024
+
+type compiledRegex struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// compileRegex is regexp.Compile with the result cached by pattern.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	compiledRegexes.RLock()
+	result, ok := compiledRegexes.results[pattern]
+	compiledRegexes.RUnlock()
+	if ok {
+		return result.re, result.err
+	}
+	result.re, result.err = regexp.Compile(pattern)
+	compiledRegexes.Lock()
+	defer compiledRegexes.Unlock()
+	if len(compiledRegexes.results) >= maxCompiledRegexes {
+		clear(compiledRegexes.results)
+	}
+	compiledRegexes.results[pattern] = result
+	return result.re, result.err
+}
+
+// precompileRegex compiles a pattern when the config is loaded. It returns nil
+// for an empty or invalid pattern, which is then left to compileRegex.
+func precompileRegex(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	re, _ := regexp.Compile(pattern)
+	return re
+}
+
+// regexOrCompile returns re, compiled with the config, or else compiles
+// pattern through the cache.
+func regexOrCompile(re *regexp.Regexp, pattern string) (*regexp.Regexp, error) {
+	if re != nil {
+		return re, nil
+	}
+	return compileRegex(pattern)
+}
+
+// ansiRegex matches ANSI escape sequences: CSI sequences such as colors and
+// cursor movement, OSC sequences such as hyperlinks and titles, and the
+// remaining two-character escapes.
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// applyFilter keeps the elements of an array source that are objects
+// satisfying the filter condition, whose field is a path within each element.
+// Other elements are dropped; a source that is not an array produces nothing.
+func (md *MappingDefinition) applyFilter(in map[string]any) (any, bool) {
+	arr, ok := getValueByPath(in, md.Src).([]any)
+	if !ok {
+		return nil, false
+	}
+	kept := []any{}
+	for _, elem := range arr {
+		if obj, ok := asStringMap(elem); ok && md.Filter.Check(obj) {
+			kept = append(kept, elem)
+		}
+	}
+	return kept, true
+}
+
+var maskRegex = regexp.MustCompile(`^keep-(first|last)-(\d+)$`)
+
+// applyMask replaces all but the first or last N characters of a string source
+// with "*", as in "keep-last-4". Strings of N characters or fewer are left
+// as-is, and non-string sources pass through unchanged.
+func (md *MappingDefinition) applyMask(in map[string]any) (any, bool) {
+	m := maskRegex.FindStringSubmatch(md.Mask)
+	if m == nil {
+		return nil, false
+	}
+	srcVal := getValueByPath(in, md.Src)
+	s, ok := srcVal.(string)
+	if !ok {
+		return srcVal, srcVal != nil
+	}
+	keep, _ := strconv.Atoi(m[2])
+	runes := []rune(s)
+	if len(runes) <= keep {
+		return s, true
+	}
+	masked := strings.Repeat("*", len(runes)-keep)
+	if m[1] == "first" {
+		return string(runes[:keep]) + masked, true
+	}
+	return masked + string(runes[len(runes)-keep:]), true
+}
+
+// applySubstr slices a string source by rune index: [start] runs to the end,
+// [start, end] excludes end. Negative indices count from the end of the string,
+// and out-of-range indices are clamped.
+func (md *MappingDefinition) applySubstr(in map[string]any) (any, bool) {
+	if len(md.Substr) < 1 || len(md.Substr) > 2 {
+		return nil, false
+	}
+	srcVal, ok := getValueByPath(in, md.Src).(string)
+	if !ok {
+		return nil, false
+	}
+	runes := []rune(srcVal)
+	clamp := func(i int) int {
+		if i < 0 {
+			i += len(runes)
+		}
+		return min(max(i, 0), len(runes))
+	}
+	start, end := clamp(md.Substr[0]), len(runes)
+	if len(md.Substr) == 2 {
+		end = clamp(md.Substr[1])
+	}
+	if start >= end {
+		return "", true
+	}
+	return string(runes[start:end]), true
+}
+
+// autoTimeLayouts are the timestamp layouts auto-time tries, in order.
+var autoTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02/Jan/2006:15:04:05 -0700", // common log format
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.ANSIC,
+	time.UnixDate,
+	time.Stamp,
+}
+
+// applyAutoTime parses a timestamp in any of the autoTimeLayouts, or as Unix
+// seconds or milliseconds, and reformats it with Format (RFC 3339 by default).
+func (md *MappingDefinition) applyAutoTime(in map[string]any) (any, bool) {
+	t, ok := parseAutoTime(getValueByPath(in, md.Src))
+	if !ok {
+		return nil, false
+	}
+	layout := md.Format
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return t.Format(layout), true
+}
+
+// dotenvFiles caches parsed dotenv files by path, so that each file is read
+// once per run, at config load, however many records use it.
+var dotenvFiles = struct {
+	sync.Mutex
+	values map[string]map[string]string
+}{values: map[string]map[string]string{}}
+
+// loadDotenv returns the values of a dotenv file, reading it on first use.
+func loadDotenv(path string) (map[string]string, error) {
+	dotenvFiles.Lock()
+	defer dotenvFiles.Unlock()
+	if values, ok := dotenvFiles.values[path]; ok {
+		return values, nil
+	}
+	// The path is intentionally supplied by the config author.
+	// #nosec G304
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := parseDotenv(string(data))
+	dotenvFiles.values[path] = values
+	return values, nil
+}
+
+// parseDotenv parses KEY=VALUE lines. Blank lines and lines starting with #
+// are skipped, an "export " prefix is allowed, and a value in matching single
+// or double quotes is unquoted; otherwise a " #" starts a trailing comment.
+func parseDotenv(data string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		} else if i := strings.Index(val, " #"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+		values[strings.TrimSpace(key)] = val
+	}
+	return values
+}
+
+// now is the clock used by age; tests replace it for stable results.
+var now = time.Now
+
+// applyAge parses a birthdate, with Format as its layout or else as for
+// auto-time, and returns the whole years from it to now. Dates in the future
+// produce nothing.
+func (md *MappingDefinition) applyAge(in map[string]any) (any, bool) {
+	val := getValueByPath(in, md.Src)
+	var born time.Time
+	if md.Format != "" {
+		s, ok := val.(string)
+		if !ok {
+			return nil, false
+		}
+		t, err := time.Parse(md.Format, strings.TrimSpace(s))
+		if err != nil {
+			return nil, false
+		}
+		born = t
+	} else if t, ok := parseAutoTime(val); ok {
+		born = t
+	} else {
+		return nil, false
+	}
+	today := now()
+	years := today.Year() - born.Year()
+	if today.Month() < born.Month() || (today.Month() == born.Month() && today.Day() < born.Day()) {
+		years-- // The birthday has not come yet this year.
+	}
+	if years < 0 {
+		return nil, false
+	}
+	return years, true
+}
+
+// parseAutoTime probes the known layouts. Numbers are Unix timestamps: values
+// of 1e11 or more are taken as milliseconds, which covers seconds until the
+// year 5138.
+func parseAutoTime(v any) (time.Time, bool) {
+	if s, ok := v.(string); ok {
+		s = strings.TrimSpace(s)
+		for _, layout := range autoTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	n, ok := toFloat(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	if math.Abs(n) >= 1e11 {
+		return time.UnixMilli(int64(n)).UTC(), true
+	}
+	sec, frac := math.Modf(n)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true
+}
+
+// applyPad fits a string or number source to an exact width in runes, given
+// as [width] or [width, "char"] (a space by default). padleft right-justifies
+// and, as for numeric fixed-width fields, truncates a longer value from the
+// left; padright left-justifies and truncates from the right.
+func (md *MappingDefinition) applyPad(in map[string]any, spec []any, left bool) (any, bool) {
+	if len(spec) < 1 || len(spec) > 2 {
+		return nil, false
+	}
+	width, ok := spec[0].(int)
+	if !ok || width < 0 {
+		return nil, false
+	}
+	pad := ' '
+	if len(spec) == 2 {
+		s, ok := spec[1].(string)
+		if !ok || utf8.RuneCountInString(s) != 1 {
+			return nil, false
+		}
+		pad, _ = utf8.DecodeRuneInString(s)
+	}
+	var runes []rune
+	switch v := getValueByPath(in, md.Src).(type) {
+	case string:
+		runes = []rune(v)
+	case float64:
+		runes = []rune(strconv.FormatFloat(v, 'f', -1, 64))
+	case int:
+		runes = []rune(strconv.Itoa(v))
+	default:
+		return nil, false
+	}
+	if len(runes) >= width {
+		if left {
+			return string(runes[len(runes)-width:]), true
+		}
+		return string(runes[:width]), true
+	}
+	padding := strings.Repeat(string(pad), width-len(runes))
+	if left {
+		return padding + string(runes), true
+	}
+	return string(runes) + padding, true
+}
+
+// applyPercent computes part/whole*100 from the two Percent paths, rounded to
+// Precision decimal places (2 by default). With PctSign the result is a
+// string with a trailing "%". A zero whole or non-numeric values produce
+// nothing.
+func (md *MappingDefinition) applyPercent(in map[string]any) (any, bool) {
+	if len(md.Percent) != 2 {
+		return nil, false
+	}
+	part, ok := toFloat(getValueByPath(in, md.Percent[0]))
+	if !ok {
+		return nil, false
+	}
+	whole, ok := toFloat(getValueByPath(in, md.Percent[1]))
+	if !ok || whole == 0 {
+		return nil, false
+	}
+	precision := 2
+	if md.Precision != nil {
+		precision = max(*md.Precision, 0)
+	}
+	scale := math.Pow(10, float64(precision))
+	pct := math.Round(part/whole*100*scale) / scale
+	if md.PctSign {
+		return strconv.FormatFloat(pct, 'f', precision, 64) + "%", true
+	}
+	return pct, true
+}
+
+// extremeValue returns the value at paths that is the most extreme according
+// to better, comparing values as numbers. Non-numeric and missing values are
+// ignored; if there are no numeric values there is no result.
+func extremeValue(in map[string]any, paths []string, better func(a, b float64) bool) (any, bool) {
+	var result float64
+	found := false
+	for _, path := range paths {
+		f, ok := toFloat(getValueByPath(in, path))
+		if !ok {
+			continue
+		}
+		if !found || better(f, result) {
+			result, found = f, true
+		}
+	}
+	return result, found
+}
+
+// schemaSignature describes the shape of a value as the sorted, comma-joined
+// dot paths of all its keys. Elements of arrays are merged under a "[]" suffix,
+// so values with the same structure always produce the same signature.
+func schemaSignature(v any) string {
+	paths := make(map[string]struct{})
+	collectKeyPaths(v, "", paths)
+	return strings.Join(slices.Sorted(maps.Keys(paths)), ",")
+}
+
+func collectKeyPaths(v any, prefix string, paths map[string]struct{}) {
+	if m, ok := asStringMap(v); ok {
+		for k, child := range m {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			paths[path] = struct{}{}
+			collectKeyPaths(child, path, paths)
+		}
+		return
+	}
+	if items, ok := v.([]any); ok {
+		for _, item := range items {
+			collectKeyPaths(item, prefix+"[]", paths)
+		}
+	}
+}
+
+// convertType converts a value to the named type: "int", "float", "bool", or
+// "string". Values that cannot be converted produce nothing.
+func convertType(v any, typ string) (any, bool) {
+	switch typ {
+	case "int":
+		if s, ok := v.(string); ok {
+			if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				return n, true
+			}
+		}
+		f, ok := toFloat(v)
+		if !ok || f != math.Trunc(f) {
+			return nil, false
+		}
+		return int(f), true
+	case "float":
+		return toFloat(v)
+	case "bool":
+		return parseBool(v)
+	case "string":
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+		if f, ok := v.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), true
+		}
+		return fmt.Sprint(v), true
+	}
+	return nil, false
+}
+
+// valueText is the text a value is compared by, e.g. against case keys. Floats
+// are written out in full, so that 1000000 is "1000000" and not "1e+06".
+func valueText(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// toFloat converts a number, or a string holding one, to a float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseBool recognizes common boolean representations such as "Y"/"N",
+// "1"/"0", "yes"/"no", and "on"/"off", case-insensitively.
+func parseBool(v any) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case int:
+		return parseBool(strconv.Itoa(b))
+	case float64:
+		return parseBool(strconv.FormatFloat(b, 'f', -1, 64))
+	case string:
+		switch strings.ToLower(strings.TrimSpace(b)) {
+		case "true", "t", "yes", "y", "1", "on":
+			return true, true
+		case "false", "f", "no", "n", "0", "off":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// expandCaptures replaces $1, $2, … in a template with the captured groups.
+func expandCaptures(template string, matches []string) string {
+	result := template
+	for i, match := range matches[1:] {
+		placeholder := fmt.Sprintf("$%d", i+1)
+		result = strings.ReplaceAll(result, placeholder, match)
+	}
+	return result
+}
@@ -0,0 +1,1496 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_applyMapping_lookupPath(t *testing.T) {
+	t.Run("extract then lookup", func(t *testing.T) {
+		in := map[string]any{
+			"raw": "user id=u42 logged in",
+			"entities": map[string]any{
+				"u42": map[string]any{"name": "Alice"},
+			},
+		}
+		out := map[string]any{}
+		outSpec := OutputMap{
+			"src":         "raw",
+			"regex":       "id=(\\w+)",
+			"lookup-path": "entities.$1.name",
+		}
+		applyMapping("user", in, out, outSpec)
+		if got, want := out["user"], "Alice"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("regex does not match", func(t *testing.T) {
+		in := map[string]any{
+			"raw":      "anonymous request",
+			"entities": map[string]any{"u42": map[string]any{"name": "Alice"}},
+		}
+		out := map[string]any{}
+		outSpec := OutputMap{
+			"src":         "raw",
+			"regex":       "id=(\\w+)",
+			"lookup-path": "entities.$1.name",
+		}
+		applyMapping("user", in, out, outSpec)
+		if _, exists := out["user"]; exists {
+			t.Errorf("expected no mapping created when regex does not match")
+		}
+	})
+
+	t.Run("lookup path missing", func(t *testing.T) {
+		in := map[string]any{
+			"raw":      "id=u99",
+			"entities": map[string]any{"u42": map[string]any{"name": "Alice"}},
+		}
+		out := map[string]any{}
+		outSpec := OutputMap{
+			"src":         "raw",
+			"regex":       "id=(\\w+)",
+			"lookup-path": "entities.$1.name",
+		}
+		applyMapping("user", in, out, outSpec)
+		if _, exists := out["user"]; exists {
+			t.Errorf("expected no mapping created when the looked-up path is missing")
+		}
+	})
+}
+
+func Test_applyMapping_word(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]any
+		outSpec OutputMap
+		want    any
+		wantSet bool
+	}{
+		{
+			name:    "second whitespace token",
+			in:      map[string]any{"full_name": "Ada   Lovelace"},
+			outSpec: OutputMap{"src": "full_name", "word": 2},
+			want:    "Lovelace",
+			wantSet: true,
+		},
+		{
+			name:    "custom separator",
+			in:      map[string]any{"path": "usr/local/bin"},
+			outSpec: OutputMap{"src": "path", "word": 3, "sep": "/"},
+			want:    "bin",
+			wantSet: true,
+		},
+		{
+			name:    "out of range",
+			in:      map[string]any{"full_name": "Cher"},
+			outSpec: OutputMap{"src": "full_name", "word": 2},
+			wantSet: false,
+		},
+		{
+			name:    "out of range writes default",
+			in:      map[string]any{"full_name": "Cher"},
+			outSpec: OutputMap{"src": "full_name", "word": 2, "default": "n/a"},
+			want:    "n/a",
+			wantSet: true,
+		},
+		{
+			name:    "zero index",
+			in:      map[string]any{"full_name": "Ada Lovelace"},
+			outSpec: OutputMap{"src": "full_name", "word": 0, "default": "n/a"},
+			want:    "n/a",
+			wantSet: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("result", tt.in, out, tt.outSpec)
+			got, exists := out["result"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_keyRegex(t *testing.T) {
+	in := map[string]any{
+		"metric_cpu": 0.5,
+		"metric_mem": 128,
+		"host":       "web-1",
+		"stats":      map[string]any{"metric_disk": 10, "uptime": 99},
+	}
+
+	t.Run("select top-level keys by prefix", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("metrics", in, out, OutputMap{"key-regex": "^metric_"})
+		want := map[string]any{"metric_cpu": 0.5, "metric_mem": 128}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+
+	t.Run("select keys of a nested object", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("metrics", in, out, OutputMap{"src": "stats", "key-regex": "^metric_"})
+		want := map[string]any{"metric_disk": 10}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+
+	t.Run("no matching keys", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("metrics", in, out, OutputMap{"key-regex": "^trace_"})
+		if len(out) != 0 {
+			t.Errorf("expected no keys, got %v", out)
+		}
+	})
+}
+
+func Test_applyMapping_toBool(t *testing.T) {
+	tests := []struct {
+		src     any
+		want    any
+		wantSet bool
+	}{
+		{"Y", true, true},
+		{"n", false, true},
+		{"1", true, true},
+		{"0", false, true},
+		{"Yes", true, true},
+		{"no", false, true},
+		{" TRUE ", true, true},
+		{"off", false, true},
+		{float64(1), true, true},
+		{true, true, true},
+		{"maybe", nil, false},
+		{float64(2), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v", tt.src), func(t *testing.T) {
+			in := map[string]any{"active": tt.src}
+			out := map[string]any{}
+			applyMapping("active", in, out, OutputMap{"src": "active", "to-bool": true})
+			got, exists := out["active"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unrecognized writes default", func(t *testing.T) {
+		in := map[string]any{"active": "maybe"}
+		out := map[string]any{}
+		applyMapping("active", in, out, OutputMap{"src": "active", "to-bool": true, "default": false})
+		if got, want := out["active"], false; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_applyMapping_clamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		want    any
+		wantSet bool
+	}{
+		{"below min", float64(-5), float64(0), true},
+		{"in range", float64(42.5), float64(42.5), true},
+		{"above max", 150, float64(100), true},
+		{"numeric string", "101", float64(100), true},
+		{"non-numeric", "high", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := map[string]any{"cpu": tt.src}
+			out := map[string]any{}
+			applyMapping("cpu", in, out, OutputMap{"src": "cpu", "clamp": []any{0, 100}})
+			got, exists := out["cpu"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("non-numeric writes default", func(t *testing.T) {
+		in := map[string]any{"cpu": "high"}
+		out := map[string]any{}
+		applyMapping("cpu", in, out, OutputMap{"src": "cpu", "clamp": []any{0, 100}, "default": 0})
+		if got, want := out["cpu"], 0; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_applyMapping_schemaSig(t *testing.T) {
+	sig := func(in map[string]any) any {
+		out := map[string]any{}
+		applyMapping("shape", in, out, OutputMap{"schema-sig": true})
+		return out["shape"]
+	}
+
+	a := map[string]any{"id": 1, "user": map[string]any{"name": "a", "age": 3}, "tags": []any{"x"}}
+	b := map[string]any{"user": map[string]any{"age": 9, "name": "b"}, "tags": []any{}, "id": "2"}
+	c := map[string]any{"id": 1, "user": map[string]any{"name": "a"}, "tags": []any{"x"}}
+	d := map[string]any{"id": 1, "lines": []any{map[string]any{"sku": "a"}, map[string]any{"qty": 2}}}
+
+	if got, want := sig(a), "id,tags,user,user.age,user.name"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if sig(a) != sig(b) {
+		t.Errorf("identical shapes produced different signatures: %v vs %v", sig(a), sig(b))
+	}
+	if sig(a) == sig(c) {
+		t.Errorf("differing shapes produced the same signature: %v", sig(a))
+	}
+	if got, want := sig(d), "id,lines,lines[].qty,lines[].sku"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	t.Run("signature of a nested object", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("shape", a, out, OutputMap{"src": "user", "schema-sig": true})
+		if got, want := out["shape"], "age,name"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_applyMapping_normalize(t *testing.T) {
+	composed := "caf\u00e9"    // é as a single code point
+	decomposed := "cafe\u0301" // e followed by a combining acute accent
+
+	normalize := func(src any, form string) (any, bool) {
+		out := map[string]any{}
+		applyMapping("name", map[string]any{"name": src}, out, OutputMap{"src": "name", "normalize": form})
+		val, ok := out["name"]
+		return val, ok
+	}
+
+	for _, form := range []string{"nfc", "NFKC"} {
+		a, _ := normalize(composed, form)
+		b, _ := normalize(decomposed, form)
+		if a != composed || b != composed {
+			t.Errorf("%s: got %q and %q, want both %q", form, a, b, composed)
+		}
+	}
+	for _, form := range []string{"nfd", "nfkd"} {
+		a, _ := normalize(composed, form)
+		b, _ := normalize(decomposed, form)
+		if a != decomposed || b != decomposed {
+			t.Errorf("%s: got %q and %q, want both %q", form, a, b, decomposed)
+		}
+	}
+	if got, _ := normalize("\uff21", "nfkc"); got != "A" {
+		t.Errorf("nfkc of fullwidth A = %q, want %q", got, "A")
+	}
+	if _, ok := normalize(composed, "nfx"); ok {
+		t.Errorf("expected no output for an unknown form")
+	}
+	if _, ok := normalize(42, "nfc"); ok {
+		t.Errorf("expected no output for a non-string source")
+	}
+}
+
+func Test_applyMapping_queryParam(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     any
+		want    any
+		wantSet bool
+	}{
+		{"present", "https://example.com/p?utm_source=news&x=1", "news", true},
+		{"escaped value", "/landing?utm_source=big%20news", "big news", true},
+		{"empty value", "https://example.com/?utm_source=", "", true},
+		{"absent", "https://example.com/p?x=1", "(none)", true},
+		{"malformed", "http://[::1/p?utm_source=news", "(none)", true},
+		{"not a string", 42, "(none)", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := map[string]any{"url": tt.url}
+			out := map[string]any{}
+			applyMapping("source", in, out, OutputMap{"src": "url", "query-param": "utm_source", "default": "(none)"})
+			got, exists := out["source"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_urlParse(t *testing.T) {
+	parse := func(src any) (any, bool) {
+		out := map[string]any{}
+		applyMapping("url", map[string]any{"url": src}, out, OutputMap{"src": "url", "url-parse": true})
+		val, ok := out["url"]
+		return val, ok
+	}
+
+	t.Run("full url", func(t *testing.T) {
+		got, _ := parse("https://example.com:8443/a/b?x=1&tag=a&tag=b#top")
+		want := OutputMap{
+			"scheme":   "https",
+			"host":     "example.com",
+			"port":     "8443",
+			"path":     "/a/b",
+			"query":    OutputMap{"x": "1", "tag": []any{"a", "b"}},
+			"fragment": "top",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("path only", func(t *testing.T) {
+		got, _ := parse("/search?q=go")
+		want := OutputMap{
+			"scheme":   "",
+			"host":     "",
+			"port":     "",
+			"path":     "/search",
+			"query":    OutputMap{"q": "go"},
+			"fragment": "",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		if got, ok := parse("http://[::1/p"); ok {
+			t.Errorf("expected no output, got %v", got)
+		}
+	})
+}
+
+func Test_applyMapping_func(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      any
+		fn       string
+		acronyms []string
+		want     any
+	}{
+		{name: "title with acronyms", src: "api gateway id", fn: "title", acronyms: []string{"API", "ID", "URL"}, want: "API Gateway ID"},
+		{name: "title lowercases the rest", src: "hELLO wORLD", fn: "title", want: "Hello World"},
+		{name: "title keeps separators", src: "user_url-path  x", fn: "title", acronyms: []string{"URL"}, want: "User_URL-Path  X"},
+		{name: "upper", src: "Api", fn: "upper", want: "API"},
+		{name: "lower", src: "Api", fn: "lower", want: "api"},
+		{name: "non-string", src: 42, fn: "title", want: nil},
+		{name: "unknown func", src: "x", fn: "reverse", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			def := OutputMap{"src": "name", "func": tt.fn}
+			if tt.acronyms != nil {
+				def["acronyms"] = tt.acronyms
+			}
+			applyMapping("name", map[string]any{"name": tt.src}, out, def)
+			if got := out["name"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_mask(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		mask string
+		want any
+	}{
+		{name: "keep last 4", src: "4111111111111111", mask: "keep-last-4", want: "************1111"},
+		{name: "keep first 2", src: "secret", mask: "keep-first-2", want: "se****"},
+		{name: "short string", src: "411", mask: "keep-last-4", want: "411"},
+		{name: "exact length", src: "4111", mask: "keep-last-4", want: "4111"},
+		{name: "multibyte", src: "ñandú", mask: "keep-last-1", want: "****ú"},
+		{name: "non-string passes through", src: 4111.0, mask: "keep-last-4", want: 4111.0},
+		{name: "unknown mask", src: "4111111111111111", mask: "keep-middle-4", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("card", map[string]any{"card": tt.src}, out, OutputMap{"src": "card", "mask": tt.mask})
+			if got := out["card"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_lookupCoalesceDefault(t *testing.T) {
+	def := OutputMap{
+		"src":      "code",
+		"lookup":   map[string]any{"A": "Active", "1": "One", "2500000": "Big"},
+		"coalesce": []any{"label", "code"},
+		"default":  "?",
+	}
+	tests := []struct {
+		name   string
+		record map[string]any
+		want   any
+	}{
+		{name: "lookup wins", record: map[string]any{"code": "A", "label": "ignored"}, want: "Active"},
+		{name: "lookup on number", record: map[string]any{"code": 1.0}, want: "One"},
+		{name: "lookup on large number", record: map[string]any{"code": 2500000.0}, want: "Big"},
+		{name: "coalesce first path", record: map[string]any{"code": "Z", "label": "Zed"}, want: "Zed"},
+		{name: "coalesce skips empty", record: map[string]any{"code": "Z", "label": ""}, want: "Z"},
+		{name: "default last", record: map[string]any{"other": "x"}, want: "?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("status", tt.record, out, def)
+			if got := out["status"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("coalesce without src", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("name", map[string]any{"nick": "Al"}, out, OutputMap{"coalesce": []any{"name", "nick"}})
+		if got := out["name"]; got != "Al" {
+			t.Errorf("got %v, want %v", got, "Al")
+		}
+	})
+}
+
+func Test_applyMapping_pipeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]any
+		outSpec OutputMap
+		want    any
+		wantSet bool
+	}{
+		{
+			name:    "src then func",
+			in:      map[string]any{"name": "api key"},
+			outSpec: OutputMap{"src": "name", "func": "title", "acronyms": []any{"API"}, "default": "none"},
+			want:    "API Key",
+			wantSet: true,
+		},
+		{
+			name:    "missing src falls back to default",
+			in:      map[string]any{},
+			outSpec: OutputMap{"src": "name", "func": "title", "default": "none"},
+			want:    "none",
+			wantSet: true,
+		},
+		{
+			name:    "func failure falls back to default",
+			in:      map[string]any{"name": 42},
+			outSpec: OutputMap{"src": "name", "func": "upper", "default": "none"},
+			want:    "none",
+			wantSet: true,
+		},
+		{
+			name:    "regex then func",
+			in:      map[string]any{"path": "/users/ada/profile"},
+			outSpec: OutputMap{"src": "path", "regex": "^/users/([^/]+)", "value": "$1", "func": "upper"},
+			want:    "ADA",
+			wantSet: true,
+		},
+		{
+			name:    "regex miss skips func and default applies",
+			in:      map[string]any{"path": "/teams/x"},
+			outSpec: OutputMap{"src": "path", "regex": "^/users/([^/]+)", "value": "$1", "func": "upper", "default": "-"},
+			want:    "-",
+			wantSet: true,
+		},
+		{
+			name:    "fallback values are not transformed",
+			in:      map[string]any{"nick": "al"},
+			outSpec: OutputMap{"src": "name", "func": "upper", "coalesce": []any{"nick"}},
+			want:    "al",
+			wantSet: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("result", tt.in, out, tt.outSpec)
+			got, exists := out["result"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_percent(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]any
+		outSpec OutputMap
+		want    any
+		wantSet bool
+	}{
+		{
+			name:    "default precision",
+			in:      map[string]any{"used": 1.0, "total": 3.0},
+			outSpec: OutputMap{"percent": []any{"used", "total"}},
+			want:    33.33,
+			wantSet: true,
+		},
+		{
+			name:    "precision and sign",
+			in:      map[string]any{"used": "2", "total": 3},
+			outSpec: OutputMap{"percent": []any{"used", "total"}, "precision": 1, "percent-sign": true},
+			want:    "66.7%",
+			wantSet: true,
+		},
+		{
+			name:    "zero precision",
+			in:      map[string]any{"disk": map[string]any{"used": 45.0, "total": 90.0}},
+			outSpec: OutputMap{"percent": []any{"disk.used", "disk.total"}, "precision": 0},
+			want:    50.0,
+			wantSet: true,
+		},
+		{
+			name:    "zero total",
+			in:      map[string]any{"used": 1.0, "total": 0.0},
+			outSpec: OutputMap{"percent": []any{"used", "total"}},
+			wantSet: false,
+		},
+		{
+			name:    "zero total with default",
+			in:      map[string]any{"used": 1.0, "total": 0.0},
+			outSpec: OutputMap{"percent": []any{"used", "total"}, "default": "n/a"},
+			want:    "n/a",
+			wantSet: true,
+		},
+		{
+			name:    "non-numeric",
+			in:      map[string]any{"used": "lots", "total": 10.0},
+			outSpec: OutputMap{"percent": []any{"used", "total"}},
+			wantSet: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("result", tt.in, out, tt.outSpec)
+			got, exists := out["result"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_substr(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    any
+		substr []any
+		want   any
+	}{
+		{name: "prefix", src: "ABCDEF", substr: []any{0, 3}, want: "ABC"},
+		{name: "multibyte runes", src: "日本語テキスト", substr: []any{0, 3}, want: "日本語"},
+		{name: "start only", src: "héllo", substr: []any{1}, want: "éllo"},
+		{name: "negative suffix", src: "naïve", substr: []any{-3}, want: "ïve"},
+		{name: "negative end", src: "naïve", substr: []any{0, -2}, want: "naï"},
+		{name: "clamped end", src: "abc", substr: []any{1, 10}, want: "bc"},
+		{name: "clamped start", src: "abc", substr: []any{-10, 2}, want: "ab"},
+		{name: "empty range", src: "abc", substr: []any{2, 1}, want: ""},
+		{name: "non-string", src: 12345, substr: []any{0, 3}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("code", map[string]any{"code": tt.src}, out, OutputMap{"src": "code", "substr": tt.substr})
+			if got := out["code"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_autoTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    any
+		format string
+		want   any
+	}{
+		{name: "RFC3339", src: "2024-03-05T10:20:30Z", format: "2006-01-02", want: "2024-03-05"},
+		{name: "RFC3339 with offset", src: "2024-03-05T23:20:30-05:00", format: "2006-01-02 15:04", want: "2024-03-05 23:20"},
+		{name: "space separated", src: "2024-03-05 10:20:30", format: "2006-01-02", want: "2024-03-05"},
+		{name: "common log format", src: "05/Mar/2024:10:20:30 +0000", format: "2006-01-02", want: "2024-03-05"},
+		{name: "unix seconds", src: 1709634030.0, format: "2006-01-02", want: "2024-03-05"},
+		{name: "unix seconds string", src: "1709634030", format: "2006-01-02", want: "2024-03-05"},
+		{name: "unix millis", src: 1709634030123.0, format: "15:04:05.000", want: "10:20:30.123"},
+		{name: "default RFC3339 output", src: "Tue, 05 Mar 2024 10:20:30 +0000", want: "2024-03-05T10:20:30Z"},
+		{name: "unparseable", src: "yesterday", format: "2006-01-02", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			def := OutputMap{"src": "ts", "auto-time": true}
+			if tt.format != "" {
+				def["format"] = tt.format
+			}
+			applyMapping("day", map[string]any{"ts": tt.src}, out, def)
+			if got := out["day"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_asArray(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]any
+		want any
+	}{
+		{name: "scalar", in: map[string]any{"tag": "red"}, want: []any{"red"}},
+		{name: "number", in: map[string]any{"tag": 3.0}, want: []any{3.0}},
+		{name: "object", in: map[string]any{"tag": map[string]any{"k": "v"}}, want: []any{map[string]any{"k": "v"}}},
+		{name: "array kept", in: map[string]any{"tag": []any{"red", "blue"}}, want: []any{"red", "blue"}},
+		{name: "nil", in: map[string]any{"tag": nil}, want: []any{}},
+		{name: "missing", in: map[string]any{}, want: []any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("tags", tt.in, out, OutputMap{"src": "tag", "as-array": true})
+			if got := out["tags"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_minMax(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]any
+		outSpec OutputMap
+		want    any
+		wantSet bool
+	}{
+		{
+			name:    "max of numbers",
+			in:      map[string]any{"m1": 3.0, "m2": 7.5, "m3": -1},
+			outSpec: OutputMap{"max": []any{"m1", "m2", "m3"}},
+			want:    7.5,
+			wantSet: true,
+		},
+		{
+			name:    "min of numbers",
+			in:      map[string]any{"m1": 3.0, "m2": 7.5, "m3": -1},
+			outSpec: OutputMap{"min": []any{"m1", "m2", "m3"}},
+			want:    -1.0,
+			wantSet: true,
+		},
+		{
+			name:    "mixed operands",
+			in:      map[string]any{"m1": "12", "m2": "n/a", "m3": nil, "nested": map[string]any{"m4": 4.0}},
+			outSpec: OutputMap{"min": []any{"m1", "m2", "m3", "missing", "nested.m4"}},
+			want:    4.0,
+			wantSet: true,
+		},
+		{
+			name:    "all nil",
+			in:      map[string]any{"m1": nil, "m2": nil},
+			outSpec: OutputMap{"max": []any{"m1", "m2"}},
+			wantSet: false,
+		},
+		{
+			name:    "all nil with default",
+			in:      map[string]any{},
+			outSpec: OutputMap{"max": []any{"m1", "m2"}, "default": 0},
+			want:    0,
+			wantSet: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("result", tt.in, out, tt.outSpec)
+			got, exists := out["result"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_nestedFieldsNamedLikeMappings(t *testing.T) {
+	in := map[string]any{"lo": 1, "hi": 9, "pct": 50, "name": "x", "flag": true}
+	var config Config
+	if err := yaml.Unmarshal([]byte(`
+common-output:
+  - stats:
+      min: lo
+      max: hi
+      percent: pct
+      coalesce: name
+      schema-sig: flag
+`), &config); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	for _, compile := range []bool{false, true} {
+		if compile {
+			if err := compileMappings(&config); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out := map[string]any{}
+		for _, fm := range convertFieldMappings(config.CommonOutput) {
+			applyMapping(fm.Key, in, out, fm.Output)
+		}
+		want := map[string]any{"stats": OutputMap{"min": 1, "max": 9, "percent": 50, "coalesce": "x", "schema-sig": true}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("compiled=%v: got %v, want %v", compile, out, want)
+		}
+	}
+}
+
+func Test_applyMapping_pad(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		outSpec OutputMap
+		want    any
+	}{
+		{name: "zero padded id", src: "42", outSpec: OutputMap{"padleft": []any{8, "0"}}, want: "00000042"},
+		{name: "numeric source", src: 42.0, outSpec: OutputMap{"padleft": []any{5, "0"}}, want: "00042"},
+		{name: "left justified", src: "Ada", outSpec: OutputMap{"padright": []any{6}}, want: "Ada   "},
+		{name: "multibyte", src: "Åse", outSpec: OutputMap{"padright": []any{5, "·"}}, want: "Åse··"},
+		{name: "exact width", src: "abcd", outSpec: OutputMap{"padleft": []any{4, "0"}}, want: "abcd"},
+		{name: "padleft truncates from the left", src: "123456789", outSpec: OutputMap{"padleft": []any{8, "0"}}, want: "23456789"},
+		{name: "padright truncates from the right", src: "Bartholomew", outSpec: OutputMap{"padright": []any{6, " "}}, want: "Bartho"},
+		{name: "multi-character pad", src: "x", outSpec: OutputMap{"padleft": []any{3, "ab"}}, want: nil},
+		{name: "non-string source", src: true, outSpec: OutputMap{"padleft": []any{3}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			tt.outSpec["src"] = "v"
+			applyMapping("v", map[string]any{"v": tt.src}, out, tt.outSpec)
+			if got := out["v"]; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_type(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]any
+		outSpec OutputMap
+		want    any
+		wantSet bool
+	}{
+		{
+			name:    "regex group as int",
+			in:      map[string]any{"s": "retries=12;"},
+			outSpec: OutputMap{"src": "s", "regex": `retries=(\d+)`, "value": "$1", "type": "int"},
+			want:    12,
+			wantSet: true,
+		},
+		{
+			name:    "regex miss falls back to default",
+			in:      map[string]any{"s": "none"},
+			outSpec: OutputMap{"src": "s", "regex": `retries=(\d+)`, "value": "$1", "type": "int", "default": 0},
+			want:    0,
+			wantSet: true,
+		},
+		{
+			name:    "non-integer text",
+			in:      map[string]any{"s": "v1.5"},
+			outSpec: OutputMap{"src": "s", "regex": `v(.*)`, "value": "$1", "type": "int"},
+			wantSet: false,
+		},
+		{
+			name:    "float",
+			in:      map[string]any{"s": "1.5"},
+			outSpec: OutputMap{"src": "s", "type": "float"},
+			want:    1.5,
+			wantSet: true,
+		},
+		{
+			name:    "whole float to int",
+			in:      map[string]any{"n": 3.0},
+			outSpec: OutputMap{"src": "n", "type": "int"},
+			want:    3,
+			wantSet: true,
+		},
+		{
+			name:    "bool",
+			in:      map[string]any{"s": "yes"},
+			outSpec: OutputMap{"src": "s", "type": "bool"},
+			want:    true,
+			wantSet: true,
+		},
+		{
+			name:    "number to string",
+			in:      map[string]any{"n": 42.0},
+			outSpec: OutputMap{"src": "n", "type": "string"},
+			want:    "42",
+			wantSet: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("result", tt.in, out, tt.outSpec)
+			got, exists := out["result"]
+			if exists != tt.wantSet {
+				t.Fatalf("result set = %v, want %v", exists, tt.wantSet)
+			}
+			if got != tt.want {
+				t.Errorf("got %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_stripANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		want any
+	}{
+		{name: "colors", src: "\x1b[31mERROR\x1b[0m disk \x1b[1;33mfull\x1b[m", want: "ERROR disk full"},
+		{name: "cursor movement", src: "\x1b[2K\x1b[1Gprogress 50%", want: "progress 50%"},
+		{name: "hyperlink", src: "\x1b]8;;https://example.com\x07link\x1b]8;;\x1b\\", want: "link"},
+		{name: "plain text", src: "no codes", want: "no codes"},
+		{name: "non-string passes through", src: 42.0, want: 42.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("message", map[string]any{"line": tt.src}, out, OutputMap{"src": "line", "strip-ansi": true})
+			if got := out["message"]; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_countMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		want any
+	}{
+		{name: "multiple", src: "ERROR a; ok; ERROR b; ERROR c", want: 3},
+		{name: "single", src: "WARN a; ERROR b", want: 1},
+		{name: "zero", src: "all good", want: 0},
+		{name: "non-string", src: 42.0, want: 0},
+		{name: "missing", src: nil, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("errors", map[string]any{"line": tt.src}, out, OutputMap{"src": "line", "count-matches": "ERROR"})
+			if got := out["errors"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_unique(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		want any
+	}{
+		{name: "duplicates", src: []any{"b", "a", "b", "c", "a"}, want: []any{"b", "a", "c"}},
+		{name: "already unique", src: []any{"x", "y"}, want: []any{"x", "y"}},
+		{name: "compared as text", src: []any{1.0, "1", 2.0}, want: []any{1.0, 2.0}},
+		{name: "large numbers compared in full", src: []any{1000000.0, "1000000", "1e+06"}, want: []any{1000000.0, "1e+06"}},
+		{name: "empty", src: []any{}, want: []any{}},
+		{name: "non-array passes through", src: "tag", want: "tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("tags", map[string]any{"tags": tt.src}, out, OutputMap{"src": "tags", "unique": true})
+			if got := out["tags"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_sort(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		src   any
+		want  any
+	}{
+		{name: "numbers asc", order: "asc", src: []any{10.0, 9.0, 100.0}, want: []any{9.0, 10.0, 100.0}},
+		{name: "numbers desc", order: "desc", src: []any{10.0, 9.0, 100.0}, want: []any{100.0, 10.0, 9.0}},
+		{name: "numeric strings asc", order: "asc", src: []any{"10", "9", 1.5}, want: []any{1.5, "9", "10"}},
+		{name: "strings asc", order: "asc", src: []any{"pear", "apple", "fig"}, want: []any{"apple", "fig", "pear"}},
+		{name: "strings desc", order: "desc", src: []any{"pear", "apple", "fig"}, want: []any{"pear", "fig", "apple"}},
+		{name: "mixed sorts as text", order: "asc", src: []any{"b", 10.0, 9.0, "a"}, want: []any{10.0, 9.0, "a", "b"}},
+		{name: "mixed with large number", order: "asc", src: []any{"2", 1000000.0, "x"}, want: []any{1000000.0, "2", "x"}},
+		{name: "non-array passes through", order: "asc", src: "tag", want: "tag"},
+		{name: "unknown order", order: "up", src: []any{"b", "a"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("tags", map[string]any{"tags": tt.src}, out, OutputMap{"src": "tags", "sort": tt.order})
+			if got := out["tags"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	src := []any{"b", "a"}
+	applyMapping("tags", map[string]any{"tags": src}, map[string]any{}, OutputMap{"src": "tags", "sort": "asc"})
+	if src[0] != "b" {
+		t.Errorf("source array modified: %v", src)
+	}
+}
+
+func Test_applyMapping_domain(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		want any
+	}{
+		{name: "email", src: "Jane.Doe@Example.COM", want: "example.com"},
+		{name: "url", src: "https://api.example.com:8443/v1/users?id=7", want: "api.example.com"},
+		{name: "url with userinfo", src: "ftp://bob@files.example.org/pub", want: "files.example.org"},
+		{name: "plain string", src: "not a domain", want: "none"},
+		{name: "missing domain", src: "jane@", want: "none"},
+		{name: "non-string", src: 42.0, want: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("domain", map[string]any{"contact": tt.src}, out, OutputMap{"src": "contact", "domain": true, "default": "none"})
+			if got := out["domain"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_srcDefault(t *testing.T) {
+	def := OutputMap{"src": "state", "default": "unknown"}
+	tests := []struct {
+		name   string
+		record map[string]any
+		want   any
+	}{
+		{name: "present", record: map[string]any{"state": "active"}, want: "active"},
+		{name: "missing", record: map[string]any{}, want: "unknown"},
+		{name: "null", record: map[string]any{"state": nil}, want: "unknown"},
+		{name: "falsy value kept", record: map[string]any{"state": false}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("status", tt.record, out, def)
+			if got := out["status"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_object(t *testing.T) {
+	in := map[string]any{"latitude": 51.5, "longitude": -0.1, "system": "gps", "name": "Ada Lovelace"}
+
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+common-output:
+  - geo:
+      object:
+        lat: latitude
+        lon: longitude
+        src: system
+        regex: name
+        last:
+          src: name
+          word: 2
+`), &config)
+	if err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	out := map[string]any{}
+	for _, fm := range convertFieldMappings(config.CommonOutput) {
+		applyMapping(fm.Key, in, out, fm.Output)
+	}
+	want := map[string]any{"geo": OutputMap{"lat": 51.5, "lon": -0.1, "src": "gps", "regex": "Ada Lovelace", "last": "Lovelace"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+
+	t.Run("plain object key is a field", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("meta", in, out, OutputMap{"object": "system"})
+		want := map[string]any{"meta": OutputMap{"object": "gps"}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+}
+
+func Test_applyMapping_filter(t *testing.T) {
+	in := map[string]any{
+		"items": []any{
+			map[string]any{"sku": "a", "active": "true", "qty": 5.0},
+			map[string]any{"sku": "b", "active": "false", "qty": 50.0},
+			map[string]any{"sku": "c", "active": "true", "qty": 500.0},
+		},
+		"name": "not an array",
+	}
+	tests := []struct {
+		name   string
+		filter map[string]any
+		want   any
+	}{
+		{name: "eq", filter: map[string]any{"field": "active", "eq": "true"}, want: []any{in["items"].([]any)[0], in["items"].([]any)[2]}},
+		{name: "numeric", filter: map[string]any{"field": "qty", "gte": 50}, want: []any{in["items"].([]any)[1], in["items"].([]any)[2]}},
+		{name: "no matches", filter: map[string]any{"field": "sku", "eq": "z"}, want: []any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("kept", in, out, OutputMap{"src": "items", "filter": tt.filter})
+			if got := out["kept"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("not an array", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("kept", in, out, OutputMap{"src": "name", "filter": map[string]any{"field": "active", "eq": "true"}})
+		if got, ok := out["kept"]; ok {
+			t.Errorf("got %v, want no value", got)
+		}
+	})
+}
+
+func Test_applyMapping_ifThenElse(t *testing.T) {
+	def := OutputMap{
+		"if":   map[string]any{"field": "score", "gte": 90},
+		"then": "A",
+		"else": "B",
+	}
+	tests := []struct {
+		name   string
+		record map[string]any
+		want   any
+	}{
+		{name: "then", record: map[string]any{"score": 95.0}, want: "A"},
+		{name: "then on numeric string", record: map[string]any{"score": "90"}, want: "A"},
+		{name: "else", record: map[string]any{"score": 72.0}, want: "B"},
+		{name: "else on missing field", record: map[string]any{}, want: "B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("grade", tt.record, out, def)
+			if got := out["grade"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing else uses default", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("vip", map[string]any{"tier": "free"}, out, OutputMap{
+			"if":      map[string]any{"field": "tier", "eq": "gold"},
+			"then":    true,
+			"default": false,
+		})
+		if got := out["vip"]; got != false {
+			t.Errorf("got %v, want false", got)
+		}
+	})
+
+	t.Run("quoted bound", func(t *testing.T) {
+		var config Config
+		if err := yaml.Unmarshal([]byte(`
+common-output:
+  - grade:
+      if: {field: score, gte: "90"}
+      then: A
+      else: B
+`), &config); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if err := compileMappings(&config); err != nil {
+			t.Fatalf("compileMappings() error: %v", err)
+		}
+		out := map[string]any{}
+		applyMapping("grade", map[string]any{"score": 95.0}, out, config.CommonOutput[0]["grade"])
+		if got := out["grade"]; got != "A" {
+			t.Errorf("got %v, want A", got)
+		}
+	})
+
+	t.Run("plain if key is a field", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("meta", map[string]any{"iface": "eth0"}, out, OutputMap{"if": "iface"})
+		want := map[string]any{"meta": OutputMap{"if": "eth0"}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+}
+
+func Test_applyMapping_switch(t *testing.T) {
+	def := OutputMap{
+		"switch":  "status_code",
+		"cases":   map[string]any{"200": "ok", "404": "missing", "1000000": "million", "0.5": "half"},
+		"default": "other",
+	}
+	tests := []struct {
+		name   string
+		record map[string]any
+		want   any
+	}{
+		{name: "number hit", record: map[string]any{"status_code": 404.0}, want: "missing"},
+		{name: "string hit", record: map[string]any{"status_code": "200"}, want: "ok"},
+		{name: "large number hit", record: map[string]any{"status_code": 1000000.0}, want: "million"},
+		{name: "fraction hit", record: map[string]any{"status_code": 0.5}, want: "half"},
+		{name: "default", record: map[string]any{"status_code": 500.0}, want: "other"},
+		{name: "missing source", record: map[string]any{}, want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("status", tt.record, out, def)
+			if got := out["status"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("plain switch key is a field", func(t *testing.T) {
+		out := map[string]any{}
+		applyMapping("port", map[string]any{"sw": "core-1"}, out, OutputMap{"switch": "sw"})
+		want := map[string]any{"port": OutputMap{"switch": "core-1"}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("got %v, want %v", out, want)
+		}
+	})
+}
+
+func Test_applyMapping_age(t *testing.T) {
+	origNow := now
+	defer func() { now = origNow }()
+	now = func() time.Time { return time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		dob    any
+		format string
+		want   any
+	}{
+		{name: "birthday passed", dob: "1990-01-10", want: 34},
+		{name: "birthday today", dob: "1990-03-15", want: 34},
+		{name: "birthday to come", dob: "1990-03-16", want: 33},
+		{name: "timestamp", dob: "2000-02-29T23:00:00Z", want: 24},
+		{name: "layout", dob: "16/03/2000", format: "02/01/2006", want: 23},
+		{name: "born today", dob: "2024-03-15", want: 0},
+		{name: "future date", dob: "2024-03-16", want: nil},
+		{name: "unparseable", dob: "last tuesday", want: nil},
+		{name: "layout mismatch", dob: "2000-03-16", format: "02/01/2006", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := OutputMap{"src": "dob", "age": true}
+			if tt.format != "" {
+				def["format"] = tt.format
+			}
+			out := map[string]any{}
+			applyMapping("age", map[string]any{"dob": tt.dob}, out, def)
+			if got := out["age"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyMapping_secret(t *testing.T) {
+	env := filepath.Join(t.TempDir(), ".env")
+	content := "# database\nexport DB_PASSWORD=\"s3cret # not a comment\"\nDB_USER=app # trailing comment\n\nAPI_KEY='abc'\nnot a pair\n"
+	if err := os.WriteFile(env, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		want   any
+	}{
+		{name: "quoted", secret: "DB_PASSWORD", want: "s3cret # not a comment"},
+		{name: "trailing comment", secret: "DB_USER", want: "app"},
+		{name: "single quoted", secret: "API_KEY", want: "abc"},
+		{name: "missing key uses default", secret: "NOPE", want: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]any{}
+			applyMapping("v", map[string]any{}, out, OutputMap{"secret": tt.secret, "from": env, "default": "none"})
+			if got := out["v"]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing file is a config error", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing.env")
+		config := Config{CommonOutput: []OutputMap{{"v": OutputMap{"secret": "DB_PASSWORD", "from": missing, "default": "none"}}}}
+		err := compileMappings(&config)
+		if err == nil || !strings.Contains(err.Error(), "common-output v: secrets file: ") || !strings.Contains(err.Error(), "missing.env") {
+			t.Errorf("compileMappings() error = %v, want one naming the secrets file", err)
+		}
+	})
+}
+
+func Test_compileRegex(t *testing.T) {
+	re1, err := compileRegex(`^id-(\d+)$`)
+	if err != nil {
+		t.Fatalf("compileRegex() error: %v", err)
+	}
+	re2, _ := compileRegex(`^id-(\d+)$`)
+	if re1 != re2 {
+		t.Errorf("expected the same compiled regex for the same pattern")
+	}
+	if _, err := compileRegex(`(`); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+	if _, err := compileRegex(`(`); err == nil {
+		t.Errorf("expected the cached error for an invalid pattern")
+	}
+}
+
+func Test_compileMappings(t *testing.T) {
+	const configYAML = `
+common-output:
+- version:
+    src: path
+    regex: "^/api/(v\\d+)/"
+    value: "$1"
+- errors:
+    src: msg
+    count-matches: "(?i)error|fail(ed|ure)?"
+- meta:
+    tags:
+      key-regex: "^x-"
+    info:
+      object:
+        first:
+          src: msg
+          word: 1
+- severe:
+    if: {field: msg, matches: "refused"}
+    then: true
+    else: false
+- n:
+    rownum-by: path
+specific-outputs:
+- field: path
+  capture: "^/api/v\\d+/(\\w+)/"
+  and:
+  - field: msg
+    matches: "timeout|refused"
+  output:
+  - resource: $1
+  - id:
+      src: path
+      regex: "/$1/(\\d+)$"
+      value: "$1"
+`
+	load := func() Config {
+		var cfg Config
+		if err := yaml.Unmarshal([]byte(configYAML), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		cfg.MatchRule = "all"
+		return cfg
+	}
+	raw, compiled := load(), load()
+	for range 2 { // again, as after a config merge
+		if err := compileMappings(&compiled); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := compiled.CommonOutput[0]["version"].(*MappingDefinition); !ok {
+		t.Errorf("version mapping = %T, want *MappingDefinition", compiled.CommonOutput[0]["version"])
+	}
+	if md, _ := compiled.CommonOutput[1]["errors"].(*MappingDefinition); md == nil || md.countMatch == nil {
+		t.Errorf("count-matches regex was not compiled: %#v", compiled.CommonOutput[1]["errors"])
+	}
+	if !usesMappingKey(compiled, "rownum-by") {
+		t.Error("usesMappingKey() = false after compiling, want true")
+	}
+
+	records := []map[string]any{
+		{"path": "/api/v2/users/42", "msg": "connection refused: error", "x-id": "a", "other": 1},
+		{"path": "/api/v1/orders/7", "msg": "timeout after failure"},
+		{"path": "/health", "msg": "ok"},
+	}
+	for _, record := range records {
+		want := processInput(record, raw)
+		got := processInput(record, compiled)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("compiled config got %v, want %v", got, want)
+		}
+	}
+	if got := processInput(records[0], compiled)["id"]; got != "42" {
+		t.Errorf("capture-expanded regex got %v, want 42", got)
+	}
+}
+
+func Test_compileMappings_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name:   "common output",
+			config: "common-output:\n- geo:\n    last: {src: name, word: second}\n",
+			want:   "common-output geo: last: ",
+		},
+		{
+			name:   "if condition",
+			config: "common-output:\n- grade: {if: {field: score, gte: high}, then: A}\n",
+			want:   "common-output grade: ",
+		},
+		{
+			name:   "rule output",
+			config: "specific-outputs:\n- name: audit\n  field: type\n  output:\n  - n: {src: a, clamp: low}\n",
+			want:   "specific-outputs rule audit: output n: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if err := yaml.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			err := compileMappings(&config)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("compileMappings() error = %v, want it to start with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compileRegex_bounded(t *testing.T) {
+	for i := range maxCompiledRegexes + 10 {
+		if _, err := compileRegex("^" + strconv.Itoa(i) + "$"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compiledRegexes.RLock()
+	defer compiledRegexes.RUnlock()
+	if n := len(compiledRegexes.results); n > maxCompiledRegexes {
+		t.Errorf("cache holds %d patterns, want at most %d", n, maxCompiledRegexes)
+	}
+}
+
+// BenchmarkProcessInput_regex compares a regex-heavy config with its patterns
+// compiled once at config load against compiling every pattern for every
+// record, which the "per record" case forces by clearing the regex cache.
+func BenchmarkProcessInput_regex(b *testing.B) {
+	load := func() Config {
+		var cfg Config
+		if err := yaml.Unmarshal([]byte(`
+common-output:
+- version:
+    src: path
+    regex: "^/api/(v\\d+)/"
+    value: "$1"
+- errors:
+    src: msg
+    count-matches: "(?i)error|fail(ed|ure)?"
+specific-outputs:
+- field: path
+  matches: "^/api/v\\d+/users/\\d+$"
+  and:
+  - field: msg
+    matches: "timeout|refused"
+  output:
+  - kind: user-lookup
+`), &cfg); err != nil {
+			b.Fatal(err)
+		}
+		cfg.MatchRule = "all"
+		return cfg
+	}
+	record := map[string]any{"path": "/api/v2/users/42", "msg": "connection refused: error after failure"}
+
+	b.Run("compiled", func(b *testing.B) {
+		cfg := load()
+		if err := compileMappings(&cfg); err != nil {
+			b.Fatal(err)
+		}
+		for range b.N {
+			processInput(record, cfg)
+		}
+	})
+	b.Run("per record", func(b *testing.B) {
+		cfg := load()
+		for range b.N {
+			compiledRegexes.Lock()
+			clear(compiledRegexes.results)
+			compiledRegexes.Unlock()
+			processInput(record, cfg)
+		}
+	})
+}
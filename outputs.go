@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputTargets is a repeatable flag.Value collecting "format[:path]" values.
+type outputTargets []OutputTarget
+
+func (o *outputTargets) String() string {
+	if o == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*o))
+	for _, t := range *o {
+		if t.Path != "" {
+			parts = append(parts, t.Format+":"+t.Path)
+		} else {
+			parts = append(parts, t.Format)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (o *outputTargets) Set(value string) error {
+	format, path, _ := strings.Cut(value, ":")
+	*o = append(*o, OutputTarget{Format: format, Path: path})
+	return nil
+}
+
+// outputSink pairs a formatter with the buffered writer and file it writes to.
+type outputSink struct {
+	formatter RecordFormatter
+	writer    *bufio.Writer
+	file      *os.File // nil when writing to stdout
+}
+
+// openOutputSinks creates a formatter for every configured output target.
+// Targets without a path share the stdout writer. On error, files already
+// created for earlier targets are closed and removed.
+func openOutputSinks(config *Config, stdout *bufio.Writer, inputType InputType) (sinks []*outputSink, err error) {
+	targets := config.Outputs
+	if len(targets) == 0 {
+		targets = []OutputTarget{{Format: config.OutputFormat}}
+	}
+
+	defer func() {
+		if err != nil {
+			for _, sink := range sinks {
+				sink.discard()
+			}
+			sinks = nil
+		}
+	}()
+	for _, target := range targets {
+		sink := &outputSink{writer: stdout}
+		if target.Path == "" && config.OutTemplate != "" {
+			targetConfig := *config
+			targetConfig.OutputFormat = target.Format
+			sink.formatter = newTemplateFormatter(&targetConfig)
+			sinks = append(sinks, sink)
+			continue
+		}
+		if target.Path == "" && config.Rotate > 0 {
+			targetConfig := *config
+			targetConfig.OutputFormat = target.Format
+			sink.formatter = newRotatingFormatter(&targetConfig, inputType)
+			sinks = append(sinks, sink)
+			continue
+		}
+		if target.Path != "" {
+			// The path is intentionally supplied by the CLI user.
+			// #nosec G304
+			file, err := os.Create(target.Path)
+			if err != nil {
+				return sinks, err
+			}
+			sink.file = file
+			sink.writer = bufio.NewWriter(file)
+		}
+
+		targetConfig := *config
+		targetConfig.OutputFormat = target.Format
+		formatter, err := NewFormatter(&targetConfig, sink.writer, inputType)
+		if err != nil {
+			sink.discard()
+			return sinks, err
+		}
+		sink.formatter = formatter
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// writeToSinks writes a record, or the raw line it carries, to every sink. It
+// reports whether every sink took it.
+func writeToSinks(sinks []*outputSink, obj map[string]any) bool {
+	ok := true
+	line, isRaw := rawLine(obj)
+	for _, sink := range sinks {
+		var err error
+		if isRaw {
+			err = sink.formatter.WriteRaw(line)
+		} else {
+			err = sink.formatter.WriteRecord(obj)
+		}
+		if errors.Is(err, ErrBufferLimit) {
+			fatalf("Error writing record: %v", err)
+		}
+		if err != nil {
+			log.Printf("Error writing record: %v", err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// close flushes the sink and closes its file, if any.
+func (s *outputSink) close() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// discard closes the sink's file, if any, and removes it.
+func (s *outputSink) discard() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
+
+// rotatingFormatter splits output into numbered files of at most Rotate
+// records each, named "<base>-0001.<format>" and so on. Every file is a
+// complete document with its own header and footer.
+type rotatingFormatter struct {
+	config    *Config
+	inputType InputType
+	index     int // number of the current file
+	count     int // records written to the current file
+	current   RecordFormatter
+	writer    *bufio.Writer
+	file      *os.File
+}
+
+func newRotatingFormatter(config *Config, inputType InputType) *rotatingFormatter {
+	return &rotatingFormatter{config: config, inputType: inputType}
+}
+
+// rotatedFileName returns the name of the index'th output file.
+func rotatedFileName(base, format string, index int) string {
+	ext := format
+	if ext == "jsonp" {
+		ext = "json"
+	}
+	return fmt.Sprintf("%s-%04d.%s", base, index, ext)
+}
+
+func (f *rotatingFormatter) WriteHeader() error {
+	return nil // Each file's header is written when the file is opened.
+}
+
+func (f *rotatingFormatter) WriteRecord(record map[string]any) error {
+	if err := f.next(); err != nil {
+		return err
+	}
+	return f.current.WriteRecord(record)
+}
+
+func (f *rotatingFormatter) WriteRaw(line string) error {
+	if err := f.next(); err != nil {
+		return err
+	}
+	return f.current.WriteRaw(line)
+}
+
+func (f *rotatingFormatter) WriteFooter() error {
+	return f.finish()
+}
+
+// next makes room for one more record, starting a new file when the current
+// one is full.
+func (f *rotatingFormatter) next() error {
+	if f.current != nil && f.count < f.config.Rotate {
+		f.count++
+		return nil
+	}
+	if err := f.finish(); err != nil {
+		return err
+	}
+	f.index++
+	name := rotatedFileName(f.config.Out, f.config.OutputFormat, f.index)
+	// The base path is intentionally supplied by the CLI user.
+	// #nosec G304
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	formatter, err := NewFormatter(f.config, f.writer, f.inputType)
+	if err != nil {
+		file.Close()
+		os.Remove(name)
+		f.file, f.writer = nil, nil
+		return err
+	}
+	f.current = formatter
+	f.count = 1
+	return f.current.WriteHeader()
+}
+
+// finish writes the footer of the current file and closes it.
+func (f *rotatingFormatter) finish() error {
+	if f.current == nil {
+		return nil
+	}
+	defer func() { f.current, f.writer, f.file = nil, nil, nil }()
+	if err := f.current.WriteFooter(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.writer.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// templateFormatter writes every record to its own file, named by rendering
+// -out-template with the record's values. Each file holds a single document.
+type templateFormatter struct {
+	config *Config
+}
+
+func newTemplateFormatter(config *Config) *templateFormatter {
+	return &templateFormatter{config: config}
+}
+
+func (f *templateFormatter) WriteHeader() error {
+	return nil // Each file gets its own header.
+}
+
+func (f *templateFormatter) WriteRecord(record map[string]any) error {
+	name, err := renderPathTemplate(f.config.OutTemplate, record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	// The template is intentionally supplied by the CLI user.
+	// #nosec G304
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	formatter, err := NewFormatter(f.config, writer, SingletonInput)
+	if err != nil {
+		return err
+	}
+	if err := formatter.WriteHeader(); err != nil {
+		return err
+	}
+	if err := formatter.WriteRecord(record); err != nil {
+		return err
+	}
+	if err := formatter.WriteFooter(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (f *templateFormatter) WriteRaw(line string) error {
+	return errors.New("raw output lines cannot be written with -out-template")
+}
+
+func (f *templateFormatter) WriteFooter() error {
+	return nil // Each file gets its own footer.
+}
+
+// renderPathTemplate replaces each {path} in the template with the value at
+// that path in the record. A missing value is an error. Path separators in
+// values are replaced with "_" so that a value cannot leave its directory.
+func renderPathTemplate(template string, record map[string]any) (string, error) {
+	var missing []string
+	name := placeholderRegex.ReplaceAllStringFunc(template, func(m string) string {
+		path := m[1 : len(m)-1]
+		val := getValueByPath(record, path)
+		if val == nil {
+			missing = append(missing, path)
+			return ""
+		}
+		s := strings.NewReplacer("/", "_", `\`, "_").Replace(fmt.Sprint(val))
+		if s == "" || s == "." || s == ".." {
+			s = "_"
+		}
+		return s
+	})
+	if missing != nil {
+		return "", fmt.Errorf("no value for %s in -out-template", strings.Join(missing, ", "))
+	}
+	return name, nil
+}
+
+// atomicFile is a temporary file in the same directory as its destination.
+// The destination only changes when commit renames the complete file into
+// place, so a run that fails part way leaves any existing file untouched.
+type atomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+func createAtomicFile(path string) (*atomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes the file private; keep the mode os.Create would give
+	// a new file, or the mode of the file being replaced.
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &atomicFile{File: file, path: path}, nil
+}
+
+// commit closes the temporary file and renames it to the destination.
+func (f *atomicFile) commit() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+// abort removes the temporary file unless it was committed.
+func (f *atomicFile) abort() {
+	if f.committed {
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_outputTargets_Set(t *testing.T) {
+	var targets outputTargets
+	for _, v := range []string{"json:out.json", "csv", "yaml:dir/out.yaml"} {
+		if err := targets.Set(v); err != nil {
+			t.Fatalf("Set(%q) error: %v", v, err)
+		}
+	}
+	want := outputTargets{
+		{Format: "json", Path: "out.json"},
+		{Format: "csv"},
+		{Format: "yaml", Path: "dir/out.yaml"},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("got %v, want %v", targets, want)
+	}
+	if got, want := targets.String(), "json:out.json,csv,yaml:dir/out.yaml"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func Test_main_multipleOutputs(t *testing.T) {
+	origStdin := os.Stdin
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Stdin = origStdin
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	os.Stdin = inR
+	go func() {
+		inW.Write([]byte(`[{"name": "Alice", "age": 30}, {"name": "Bob", "age": 25}]`))
+		inW.Close()
+	}()
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "out.json")
+	csvPath := filepath.Join(dir, "out.csv")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-i", "json", "-o", "json:" + jsonPath, "-o", "csv:" + csvPath}
+
+	main()
+
+	gotJSON, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("reading json output: %v", err)
+	}
+	wantJSON := "[\n" + `{"age":30,"name":"Alice"}` + ",\n" + `{"age":25,"name":"Bob"}` + "\n]"
+	if string(gotJSON) != wantJSON {
+		t.Errorf("json output got %q, want %q", gotJSON, wantJSON)
+	}
+
+	gotCSV, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatalf("reading csv output: %v", err)
+	}
+	wantCSV := "age,name\n30,Alice\n25,Bob\n"
+	if string(gotCSV) != wantCSV {
+		t.Errorf("csv output got %q, want %q", gotCSV, wantCSV)
+	}
+}
+
+func Test_openOutputSinks_cleanup(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.json")
+	tests := []struct {
+		name   string
+		second OutputTarget
+	}{
+		{name: "create fails", second: OutputTarget{Format: "csv", Path: filepath.Join(dir, "missing", "out.csv")}},
+		{name: "formatter fails", second: OutputTarget{Format: "template", Path: filepath.Join(dir, "out.txt")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Outputs: []OutputTarget{{Format: "json", Path: first}, tt.second}}
+			sinks, err := openOutputSinks(config, nil, ArrayInput)
+			if err == nil {
+				t.Fatal("openOutputSinks() error = nil, want error")
+			}
+			if sinks != nil {
+				t.Errorf("sinks = %v, want nil", sinks)
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if !e.IsDir() {
+					t.Errorf("leftover file %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func Test_main_rotate(t *testing.T) {
+	origStdin := os.Stdin
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Stdin = origStdin
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	os.Stdin = inR
+	go func() {
+		// N*2+1 records with N = 2.
+		inW.Write([]byte(`[{"n": 1}, {"n": 2}, {"n": 3}, {"n": 4}, {"n": 5}]`))
+		inW.Close()
+	}()
+
+	base := filepath.Join(t.TempDir(), "part")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-i", "json", "-o", "json", "-rotate", "2", "-out", base}
+
+	main()
+
+	want := map[string]string{
+		"part-0001.json": "[\n" + `{"n":1}` + ",\n" + `{"n":2}` + "\n]",
+		"part-0002.json": "[\n" + `{"n":3}` + ",\n" + `{"n":4}` + "\n]",
+		"part-0003.json": "[\n" + `{"n":5}` + "\n]",
+	}
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		t.Fatalf("reading output dir: %v", err)
+	}
+	if len(entries) != len(want) {
+		t.Errorf("got %d files, want %d", len(entries), len(want))
+	}
+	for name, wantContent := range want {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(base), name))
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+			continue
+		}
+		if string(got) != wantContent {
+			t.Errorf("%s got %q, want %q", name, got, wantContent)
+		}
+	}
+}
+
+func Test_rotatedFileName(t *testing.T) {
+	if got := rotatedFileName("out/data", "jsonp", 12); got != "out/data-0012.json" {
+		t.Errorf("got %q", got)
+	}
+	if got := rotatedFileName("data", "yaml", 1); got != "data-0001.yaml" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func Test_main_outTemplate(t *testing.T) {
+	origStdin := os.Stdin
+	origArgs := os.Args
+	origCommandLine := flag.CommandLine
+	defer func() {
+		os.Stdin = origStdin
+		os.Args = origArgs
+		flag.CommandLine = origCommandLine
+	}()
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	os.Stdin = inR
+	go func() {
+		inW.Write([]byte(`[{"type": "user", "id": 1}, {"type": "group", "id": "a/b"}, {"type": "user", "id": 2}]`))
+		inW.Close()
+	}()
+
+	dir := t.TempDir()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{os.Args[0], "-i", "json", "-o", "jsonl", "-out-template", filepath.Join(dir, "{type}", "{id}.json")}
+
+	main()
+
+	want := map[string]string{
+		"user/1.json":    `{"id":1,"type":"user"}` + "\n",
+		"user/2.json":    `{"id":2,"type":"user"}` + "\n",
+		"group/a_b.json": `{"id":"a/b","type":"group"}` + "\n",
+	}
+	var got []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if len(got) != len(want) {
+		t.Errorf("got files %v, want %d files", got, len(want))
+	}
+	for name, wantContent := range want {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+			continue
+		}
+		if string(content) != wantContent {
+			t.Errorf("%s got %q, want %q", name, content, wantContent)
+		}
+	}
+}
+
+func Test_main_droppedRecordFails(t *testing.T) {
+	if dir := os.Getenv("BE_CRASH_TEST_DROPPED_RECORD"); dir != "" {
+		inR, inW, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("os.Pipe failed: %v", err)
+		}
+		os.Stdin = inR
+		go func() {
+			inW.Write([]byte(`[{"type": "user", "id": 1}, {"id": 2}]`))
+			inW.Close()
+		}()
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		os.Args = []string{os.Args[0], "-i", "json", "-o", "jsonl", "-out-template", filepath.Join(dir, "{type}", "{id}.json")}
+		main()
+		return
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=Test_main_droppedRecordFails")
+	cmd.Env = append(os.Environ(), "BE_CRASH_TEST_DROPPED_RECORD="+dir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.Success() {
+		t.Fatalf("expected a non-zero exit, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "1 record(s) could not be written") {
+		t.Errorf("expected stderr to report the dropped record, got %q", stderr.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "user", "1.json")); err != nil {
+		t.Errorf("expected the other record to be written: %v", err)
+	}
+}
+
+func Test_renderPathTemplate(t *testing.T) {
+	record := map[string]any{"a": map[string]any{"b": ".."}, "c": "x"}
+	if got, err := renderPathTemplate("{c}/{a.b}.json", record); err != nil || got != "x/_.json" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := renderPathTemplate("{c}/{missing}.json", record); err == nil {
+		t.Error("expected an error for a missing placeholder value")
+	}
+}
+
+func Test_atomicFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.json")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	aborted, err := createAtomicFile(path)
+	if err != nil {
+		t.Fatalf("createAtomicFile() error: %v", err)
+	}
+	aborted.WriteString("partial")
+	aborted.abort()
+	if got, _ := os.ReadFile(path); string(got) != "old" {
+		t.Errorf("after abort got %q, want the original file", got)
+	}
+
+	committed, err := createAtomicFile(path)
+	if err != nil {
+		t.Fatalf("createAtomicFile() error: %v", err)
+	}
+	committed.WriteString("new")
+	if err := committed.commit(); err != nil {
+		t.Fatalf("commit() error: %v", err)
+	}
+	committed.abort()
+	if got, _ := os.ReadFile(path); string(got) != "new" {
+		t.Errorf("after commit got %q, want %q", got, "new")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("mode of replaced file = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("got %d files in the output directory, want 1 with no temporary files left", len(entries))
+	}
+}
+
+func Test_main_outBufferLimit(t *testing.T) {
+	if dir := os.Getenv("BE_CRASH_TEST_OUT_BUFFER_LIMIT"); dir != "" {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		os.Args = []string{os.Args[0], "-i", "json", "-o", "yaml", "-max-buffer", "1", "-out", filepath.Join(dir, "out.yaml")}
+		main()
+		return
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=Test_main_outBufferLimit")
+	cmd.Env = append(os.Environ(), "BE_CRASH_TEST_OUT_BUFFER_LIMIT="+dir)
+	cmd.Stdin = strings.NewReader(`[{"id": 1}, {"id": 2}]`)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected a non-zero exit when the buffer limit is exceeded")
+	}
+	if !strings.Contains(stderr.String(), "output buffer limit exceeded") {
+		t.Errorf("stderr = %q, want the buffer limit error", stderr.String())
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		t.Errorf("leftover file %s", e.Name())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// configSchema returns a JSON Schema for trmg config files, derived from the
+// yaml tags of Config and the types it refers to. Fields without a yaml tag
+// are command-line only and are left out.
+func configSchema() map[string]any {
+	defs := map[string]any{}
+	root := structSchema(reflect.TypeOf(Config{}), defs)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "trmg config"
+	root["$defs"] = defs
+	return root
+}
+
+// structSchema describes a struct as an object whose properties are its
+// yaml-tagged fields. Unknown properties are rejected so that editors can
+// flag misspelled keys.
+func structSchema(t reflect.Type, defs map[string]any) map[string]any {
+	props := map[string]any{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		props[name] = typeSchema(field.Type, defs)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// typeSchema describes a field type. Structs and OutputMap become entries of
+// defs referenced by name, so each is described once.
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	if t == reflect.TypeOf(OutputMap{}) {
+		if _, ok := defs["OutputMap"]; !ok {
+			defs["OutputMap"] = outputMapSchema(defs)
+		}
+		return map[string]any{"$ref": "#/$defs/OutputMap"}
+	}
+	if t == reflect.TypeOf(bound(0)) {
+		return map[string]any{"type": []string{"number", "string"}}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), defs)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // Reserve the name while recursing.
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]any{} // Any value.
+}
+
+// outputMapSchema describes an output mapping: each key maps to a source
+// path, a MappingDefinition, or a nested map of further mappings.
+func outputMapSchema(defs map[string]any) map[string]any {
+	typeSchema(reflect.TypeOf(MappingDefinition{}), defs)
+	return map[string]any{
+		"type": "object",
+		"additionalProperties": map[string]any{
+			"anyOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{"$ref": "#/$defs/MappingDefinition"},
+				map[string]any{"$ref": "#/$defs/OutputMap"},
+			},
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_configSchema(t *testing.T) {
+	data, err := json.Marshal(configSchema())
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	props := schema["properties"].(map[string]any)
+	for _, key := range []string{"match-rule", "clone-original", "common-output", "specific-outputs"} {
+		if _, ok := props[key]; !ok {
+			t.Errorf("schema has no top-level %q property", key)
+		}
+	}
+	if _, ok := props["InputFile"]; ok {
+		t.Error("schema includes a command-line-only field")
+	}
+
+	defs := schema["$defs"].(map[string]any)
+	for def, keys := range map[string][]string{
+		"SpecificOutputRule": {"field", "eq", "matches", "and", "output"},
+		"MappingDefinition":  {"src", "regex", "value", "default"},
+		"AndCondition":       {"field", "eq"},
+	} {
+		d, ok := defs[def].(map[string]any)
+		if !ok {
+			t.Errorf("schema has no %s definition", def)
+			continue
+		}
+		defProps := d["properties"].(map[string]any)
+		for _, key := range keys {
+			if _, ok := defProps[key]; !ok {
+				t.Errorf("%s has no %q property", def, key)
+			}
+		}
+	}
+
+	if !strings.Contains(string(data), `"$ref":"#/$defs/OutputMap"`) {
+		t.Error("common-output does not refer to the OutputMap definition")
+	}
+}
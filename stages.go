@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+)
+
+// deduper drops records that are identical to one already written.
+type deduper struct {
+	seen map[[sha256.Size]byte]struct{}
+}
+
+func newDeduper() *deduper {
+	return &deduper{seen: make(map[[sha256.Size]byte]struct{})}
+}
+
+// isDuplicate reports whether an identical record was seen before, and
+// remembers the record otherwise. json.Marshal sorts map keys at every level,
+// so the hash does not depend on key order.
+func (d *deduper) isDuplicate(record map[string]any) bool {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(data)
+	if _, ok := d.seen[sum]; ok {
+		return true
+	}
+	d.seen[sum] = struct{}{}
+	return false
+}
+
+// reservoir keeps a uniform random sample of k records from a stream of
+// unknown length using Algorithm R.
+type reservoir struct {
+	k       int
+	seen    int
+	records []map[string]any
+	rng     *rand.Rand
+}
+
+func newReservoir(k int, seed int64) *reservoir {
+	return &reservoir{
+		k:       k,
+		records: make([]map[string]any, 0, k),
+		rng:     rand.New(rand.NewPCG(uint64(seed), 0)),
+	}
+}
+
+// add offers a record to the sample. Once k records are held, the nth record
+// replaces a random slot with probability k/n.
+func (r *reservoir) add(record map[string]any) {
+	r.seen++
+	if len(r.records) < r.k {
+		r.records = append(r.records, record)
+		return
+	}
+	if j := r.rng.IntN(r.seen); j < r.k {
+		r.records[j] = record
+	}
+}
+
+// rownumRef is the placeholder a rownum-by mapping leaves in an output record.
+// It names the record's group; the numberer replaces it with the count.
+type rownumRef struct {
+	group string
+}
+
+// MarshalJSON lets the deduper tell records of different groups apart.
+func (r rownumRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.group)
+}
+
+// runningSumRef is the placeholder a running-sum mapping leaves in an output
+// record. It holds the record's amount; the numberer replaces it with the
+// total of its path so far.
+type runningSumRef struct {
+	path   string
+	amount float64
+}
+
+// MarshalJSON lets the deduper tell records of different amounts apart.
+func (r runningSumRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.amount)
+}
+
+// numberer assigns each record the next row number of its group and the
+// running totals of its running-sum paths. It runs as records are written,
+// so dropped and deduplicated records are not counted, and totals follow the
+// output order.
+type numberer struct {
+	counts map[string]int
+	sums   map[string]float64
+}
+
+func newNumberer() *numberer {
+	return &numberer{counts: make(map[string]int), sums: make(map[string]float64)}
+}
+
+// number replaces every rownumRef and runningSumRef in the record, including
+// in nested maps and arrays.
+func (n *numberer) number(record map[string]any) {
+	for k, v := range record {
+		record[k] = n.resolve(v)
+	}
+}
+
+// resolve returns v with its placeholders replaced. Arrays are copied rather
+// than changed in place, since they may be shared with the input record or
+// with the other copies made by -repeat.
+func (n *numberer) resolve(v any) any {
+	switch val := v.(type) {
+	case rownumRef:
+		n.counts[val.group]++
+		return n.counts[val.group]
+	case runningSumRef:
+		n.sums[val.path] += val.amount
+		return n.sums[val.path]
+	case OutputMap:
+		n.number(val)
+	case map[string]any:
+		n.number(val)
+	case []any:
+		resolved := make([]any, len(val))
+		for i, elem := range val {
+			resolved[i] = n.resolve(elem)
+		}
+		return resolved
+	}
+	return v
+}
+
+// newRownumRef resolves the group of a record for a rownum-by path.
+func newRownumRef(in map[string]any, path string) rownumRef {
+	return rownumRef{group: fmt.Sprint(getValueByPath(in, path))}
+}
+
+// newRunningSumRef resolves the amount a record adds to the running total of
+// a path. Values that are not numbers add nothing.
+func newRunningSumRef(in map[string]any, path string) runningSumRef {
+	amount, _ := toFloat(getValueByPath(in, path))
+	return runningSumRef{path: path, amount: amount}
+}
+
+// usesMappingKey reports whether any common or rule output mapping of the
+// config contains the given mapping key.
+func usesMappingKey(config Config, key string) bool {
+	var check func(v any) bool
+	check = func(v any) bool {
+		if md, ok := v.(*MappingDefinition); ok {
+			v = md.spec
+		}
+		om, ok := asStringMap(v)
+		if !ok {
+			return false
+		}
+		for k, child := range om {
+			if k == key || check(child) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, om := range config.CommonOutput {
+		if check(om) {
+			return true
+		}
+	}
+	for _, rule := range config.SpecificOutputs {
+		for _, om := range rule.Output {
+			if check(om) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flattenRecord returns a copy of record in which nested objects are replaced
+// by their leaf values, keyed by the path of keys joined with delim, e.g.
+// {"addr": {"city": "x"}} becomes {"addr.city": "x"}. Arrays and empty
+// objects are kept as values.
+func flattenRecord(record map[string]any, delim string) map[string]any {
+	flat := make(map[string]any, len(record))
+	flattenInto(flat, "", record, delim)
+	return flat
+}
+
+func flattenInto(flat map[string]any, prefix string, m map[string]any, delim string) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + delim + k
+		}
+		if nested, ok := asStringMap(v); ok && len(nested) > 0 {
+			flattenInto(flat, key, nested, delim)
+			continue
+		}
+		flat[key] = v
+	}
+}
+
+// cloneRecord copies a record and the maps nested in it. Other values,
+// including arrays, are shared with the original.
+func cloneRecord(record map[string]any) map[string]any {
+	out := make(map[string]any, len(record))
+	for k, v := range record {
+		switch val := v.(type) {
+		case OutputMap:
+			out[k] = OutputMap(cloneRecord(val))
+		case map[string]any:
+			out[k] = cloneRecord(val)
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// processConcurrently processes the records from in on config.Workers
+// goroutines and sends the results to out in input order. Results that finish
+// early wait in a window of a few records per worker, so one slow record
+// doesn't let the others pile up.
+func processConcurrently(in <-chan map[string]any, out chan<- map[string]any, config Config) {
+	defer close(out)
+	type job struct {
+		seq    int
+		record map[string]any
+	}
+	jobs := make(chan job)
+	results := make(chan job, config.Workers)
+	window := make(chan struct{}, 4*config.Workers)
+
+	var wg sync.WaitGroup
+	for range config.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- job{seq: j.seq, record: processRecord(j.record, config)}
+			}
+		}()
+	}
+	go func() {
+		seq := 0
+		for record := range in {
+			window <- struct{}{}
+			jobs <- job{seq: seq, record: record}
+			seq++
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Hold each result until all those before it have been sent.
+	pending := make(map[int]map[string]any)
+	next := 0
+	for r := range results {
+		pending[r.seq] = r.record
+		for {
+			record, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-window
+			if record != nil {
+				out <- record
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func Test_deduper(t *testing.T) {
+	d := newDeduper()
+	records := []struct {
+		name   string
+		record map[string]any
+		want   bool
+	}{
+		{"first record", map[string]any{"id": 1, "tags": map[string]any{"a": 1, "b": 2}}, false},
+		{"exact duplicate", map[string]any{"tags": map[string]any{"b": 2, "a": 1}, "id": 1}, true},
+		{"near duplicate", map[string]any{"id": 1, "tags": map[string]any{"a": 1, "b": 3}}, false},
+		{"extra key", map[string]any{"id": 1, "tags": map[string]any{"a": 1, "b": 2}, "x": nil}, false},
+		{"duplicate of near duplicate", map[string]any{"id": 1, "tags": map[string]any{"a": 1, "b": 3}}, true},
+	}
+	for _, tt := range records {
+		if got := d.isDuplicate(tt.record); got != tt.want {
+			t.Errorf("%s: isDuplicate() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_reservoir(t *testing.T) {
+	sample := func(n, k int, seed int64) []any {
+		r := newReservoir(k, seed)
+		for i := range n {
+			r.add(map[string]any{"id": i})
+		}
+		ids := make([]any, 0, len(r.records))
+		for _, rec := range r.records {
+			ids = append(ids, rec["id"])
+		}
+		return ids
+	}
+
+	first := sample(100, 5, 42)
+	if len(first) != 5 {
+		t.Fatalf("got %d records, want 5", len(first))
+	}
+	if second := sample(100, 5, 42); !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed produced different samples: %v vs %v", first, second)
+	}
+	seen := map[any]bool{}
+	for _, id := range first {
+		if seen[id] {
+			t.Errorf("record %v sampled twice in %v", id, first)
+		}
+		seen[id] = true
+	}
+	if reflect.DeepEqual(first, []any{0, 1, 2, 3, 4}) {
+		t.Errorf("sample never replaced the initial records: %v", first)
+	}
+
+	t.Run("fewer records than k", func(t *testing.T) {
+		if got, want := sample(3, 5, 1), []any{0, 1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_numberer_rownumBy(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+- region: region
+- n:
+    rownum-by: region
+`)
+	if !usesMappingKey(*cfg, "rownum-by") {
+		t.Fatalf("expected the config to use rownum-by")
+	}
+
+	n := newNumberer()
+	var got []any
+	for _, region := range []string{"us", "eu", "us", "us", "eu", "ap"} {
+		out := processInput(map[string]any{"region": region}, *cfg)
+		n.number(out)
+		got = append(got, out["region"].(string)+"-"+strconv.Itoa(out["n"].(int)))
+	}
+	want := []any{"us-1", "eu-1", "us-2", "us-3", "eu-2", "ap-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func Test_numberer_nested(t *testing.T) {
+	n := newNumberer()
+	record := map[string]any{"meta": OutputMap{"seq": rownumRef{group: "a"}}, "top": rownumRef{group: "a"}}
+	n.number(record)
+	seq := record["meta"].(OutputMap)["seq"].(int)
+	if top := record["top"].(int); seq+top != 3 {
+		t.Errorf("got seq %d and top %d, want 1 and 2 in some order", seq, top)
+	}
+}
+
+func Test_numberer_runningSum(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+- id: id
+- total:
+    running-sum: amount
+`)
+	if !usesMappingKey(*cfg, "running-sum") {
+		t.Fatalf("expected the config to use running-sum")
+	}
+
+	n := newNumberer()
+	var got []any
+	for _, amount := range []any{10.0, "20", 30.0, "n/a"} {
+		out := processInput(map[string]any{"id": "x", "amount": amount}, *cfg)
+		n.number(out)
+		got = append(got, out["total"])
+	}
+	want := []any{10.0, 30.0, 60.0, 60.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func Test_numberer_arrays(t *testing.T) {
+	n := newNumberer()
+	shared := []any{rownumRef{group: "a"}, "x", rownumRef{group: "a"}}
+	record := map[string]any{
+		"seq":  shared,
+		"deep": OutputMap{"list": []any{[]any{rownumRef{group: "b"}}, map[string]any{"n": rownumRef{group: "c"}}}},
+	}
+	n.number(record)
+	want := map[string]any{
+		"seq":  []any{1, "x", 2},
+		"deep": OutputMap{"list": []any{[]any{1}, map[string]any{"n": 1}}},
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %v, want %v", record, want)
+	}
+	if _, ok := shared[0].(rownumRef); !ok {
+		t.Errorf("the original array was changed: %v", shared)
+	}
+}
+
+func Test_writeExplanations_numbers(t *testing.T) {
+	objs := make(chan map[string]any, 2)
+	objs <- map[string]any{"rule": rownumRef{group: "a"}}
+	objs <- map[string]any{"rule": []any{rownumRef{group: "a"}}}
+	close(objs)
+
+	var buf bytes.Buffer
+	writeExplanations(objs, &buf, newNumberer())
+	if got, want := buf.String(), "0: 1\n1: [2]\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_numberer_runningSumArrays(t *testing.T) {
+	n := newNumberer()
+	record := map[string]any{
+		"totals": []any{runningSumRef{path: "amt", amount: 5}, []any{runningSumRef{path: "amt", amount: 2}}},
+		"meta":   OutputMap{"list": []any{map[string]any{"sum": runningSumRef{path: "fee", amount: 1.5}}}},
+	}
+	n.number(record)
+	want := map[string]any{
+		"totals": []any{5.0, []any{7.0}},
+		"meta":   OutputMap{"list": []any{map[string]any{"sum": 1.5}}},
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %v, want %v", record, want)
+	}
+
+	objs := make(chan map[string]any, 1)
+	objs <- map[string]any{"rule": []any{runningSumRef{path: "amt", amount: 3}}}
+	close(objs)
+	var buf bytes.Buffer
+	writeExplanations(objs, &buf, n)
+	if got, want := buf.String(), "0: [10]\n"; got != want {
+		t.Errorf("explain: got %q, want %q", got, want)
+	}
+}
+
+func Test_usesMappingKey(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+- id: id
+specific-outputs:
+- field: kind
+  eq: a
+  output:
+  - meta:
+      n:
+        rownum-by: kind
+`)
+	if !usesMappingKey(*cfg, "rownum-by") {
+		t.Errorf("expected rownum-by in a nested rule mapping to be found")
+	}
+	if usesMappingKey(*cfg, "percent") {
+		t.Errorf("did not expect percent to be found")
+	}
+}
+
+func Test_flattenRecord(t *testing.T) {
+	record := map[string]any{
+		"id": 1,
+		"addr": OutputMap{
+			"city": "Oslo",
+			"geo":  map[string]any{"lat": 59.9, "lon": 10.7},
+		},
+		"tags":  []any{"a", "b"},
+		"empty": map[string]any{},
+	}
+
+	got := flattenRecord(record, "__")
+	want := map[string]any{
+		"id":             1,
+		"addr__city":     "Oslo",
+		"addr__geo__lat": 59.9,
+		"addr__geo__lon": 10.7,
+		"tags":           []any{"a", "b"},
+		"empty":          map[string]any{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := flattenRecord(record, "."); got["addr.geo.lat"] != 59.9 {
+		t.Errorf("got %v, want addr.geo.lat with the default delimiter", got)
+	}
+}
+
+func Test_cloneRecord(t *testing.T) {
+	orig := map[string]any{"a": 1, "m": map[string]any{"b": 2}, "o": OutputMap{"c": 3}}
+	clone := cloneRecord(orig)
+	clone["m"].(map[string]any)["b"] = 20
+	clone["o"].(OutputMap)["c"] = 30
+	want := map[string]any{"a": 1, "m": map[string]any{"b": 2}, "o": OutputMap{"c": 3}}
+	if !reflect.DeepEqual(orig, want) {
+		t.Errorf("original changed to %v", orig)
+	}
+}
+
+func Test_processConcurrently(t *testing.T) {
+	cfg := mustConfig(t, `
+common-output:
+- id: id
+- code:
+    src: msg
+    regex: "code=(\\d+)"
+    value: "$1"
+specific-outputs:
+- field: drop
+  eq: "yes"
+  output:
+  - dropped: drop
+`)
+	cfg.MatchRule = "drop-no-match"
+	cfg.Workers = 4
+
+	in := make(chan map[string]any)
+	out := make(chan map[string]any)
+	go func() {
+		for i := range 200 {
+			drop := "yes"
+			if i%3 == 0 {
+				drop = "no"
+			}
+			in <- map[string]any{"id": strconv.Itoa(i), "msg": "code=" + strconv.Itoa(i), "drop": drop}
+		}
+		close(in)
+	}()
+	go processConcurrently(in, out, *cfg)
+
+	var got []string
+	for rec := range out {
+		if rec["id"] != rec["code"] {
+			t.Errorf("mismatched record %v", rec)
+		}
+		got = append(got, rec["id"].(string))
+	}
+	var want []string
+	for i := range 200 {
+		if i%3 != 0 {
+			want = append(want, strconv.Itoa(i))
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}